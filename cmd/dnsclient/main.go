@@ -5,11 +5,13 @@
 //   dnsclient -type Addr|CNAME|Host|MX|NS -name <name>
 //             -transport system|godns|tcp|udp|dot|doh
 //             -endpoint <transport-specific-endpoint>
+//             -engine oodns|godns
 //
 //   dnsclient -help
 //
 // The default is to use the system transport. For each transport
-// we use a specific default resolver.
+// we use a specific default resolver. The -engine flag selects the
+// DNS engine used with tcp, udp, dot, and doh; the default is oodns.
 //
 // We emit JSONL messages on the stdout showing what we are
 // currently doing. We also print the final result on the stdout.
@@ -44,6 +46,7 @@ import (
 var (
 	flagName      = flag.String("name", "ooni.io", "Name to query for")
 	flagEndpoint  = flag.String("endpoint", "", "Transport endpoint")
+	flagEngine    = flag.String("engine", "oodns", "DNS engine to use")
 	flagTransport = flag.String("transport", "system", "Transport to use")
 	flagType      = flag.String("type", "Host", "Query type")
 )
@@ -72,6 +75,8 @@ func mainWithContext(ctx context.Context) error {
 		fmt.Printf("%s\n", "  ./dnsclient -transport udp -endpoint 1.1.1.1:53 ...")
 		return nil
 	}
+	err = dialer.SetDNSEngine(*flagEngine)
+	rtx.Must(err, "cannot set DNS engine")
 	resolver, err = dialer.NewResolver(*flagTransport, *flagEndpoint)
 	rtx.Must(err, "cannot create new resolver")
 	if *flagType == "Addr" {
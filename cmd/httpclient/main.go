@@ -3,12 +3,17 @@
 // Usage:
 //
 //   httpclient -dns-transport system|godns|tcp|udp|dot|doh -url <URL>
+//              -dns-engine oodns|godns -proxy <socks5-or-http-proxy-URL>
 //
 //   httpclient -help
 //
-// The default is to use the system DNS. Use -dns-engine to force
+// The default is to use the system DNS. Use -dns-transport to force
 // a different type of DNS transport. We'll use a good default resolver
-// for the selected transport. This only works on Unix.
+// for the selected transport. Use -dns-engine to select the DNS engine
+// used with such transport; the default is oodns.
+//
+// When the SSLKEYLOGFILE environment variable is set, we append the
+// TLS secrets to such file using the NSS key log format.
 //
 // We emit JSONL messages on the stdout showing what we are
 // currently doing. We also print the final result on the stdout.
@@ -42,7 +47,9 @@ var (
 	flagDNSUDPServer = flag.String(
 		"dns-udp-server", "1.1.1.1:53", "Server to use with -dns-transport udp",
 	)
+	flagDNSEngine    = flag.String("dns-engine", "oodns", "DNS engine to use")
 	flagDNSTransport = flag.String("dns-transport", "", "DNS transport to use")
+	flagProxy        = flag.String("proxy", "", "SOCKS5 or HTTP proxy URL")
 	flagSNI          = flag.String("sni", "", "Force specific SNI")
 	flagURL          = flag.String("url", "https://ooni.io/", "URL to fetch")
 )
@@ -71,10 +78,20 @@ func mainfunc() (err error) {
 		fmt.Printf("%s\n", "  ./httpclient -dns-transport dot ...")
 		fmt.Printf("%s\n", "  ./httpclient -dns-transport tcp ...")
 		fmt.Printf("%s\n", "  ./httpclient -dns-transport udp [-dns-udp-server <addr>:<port>] ...")
-		fmt.Printf("\nWe'll select a suitable backend for each transport. Note\n")
-		fmt.Printf("that this only works on Unix.\n")
+		fmt.Printf("\nWe'll select a suitable backend for each transport.\n")
 		return nil
 	}
+	// Must be before ConfigureDNS, so that we also log DoT/DoH secrets
+	if path := os.Getenv("SSLKEYLOGFILE"); path != "" {
+		var filep *os.File
+		filep, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		rtx.PanicOnError(err, "cannot open SSLKEYLOGFILE")
+		defer filep.Close()
+		err = client.SetKeyLogWriter(filep)
+		rtx.PanicOnError(err, "cannot set key log writer")
+	}
+	err = client.SetDNSEngine(*flagDNSEngine)
+	rtx.PanicOnError(err, "cannot set DNS engine")
 	if *flagDNSTransport == "system" {
 		err = client.ConfigureDNS("system", "")
 	} else if *flagDNSTransport == "godns" {
@@ -91,6 +108,8 @@ func mainfunc() (err error) {
 		err = errors.New("invalid -dns-transport argument")
 	}
 	rtx.PanicOnError(err, "cannot configure DNS transport")
+	err = client.SetProxy(*flagProxy)
+	rtx.PanicOnError(err, "cannot set proxy")
 	err = client.ForceSpecificSNI(*flagSNI)
 	rtx.PanicOnError(err, "cannot force specific SNI")
 	err = fetch(client.HTTPClient, *flagURL)
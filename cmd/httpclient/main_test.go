@@ -106,3 +106,14 @@ func TestInvalidURL(t *testing.T) {
 		t.Fatal("expected an error here")
 	}
 }
+
+func TestInvalidDNSEngine(t *testing.T) {
+	*flagDNSEngine = "invalid"
+	defer func() {
+		*flagDNSEngine = "oodns"
+	}()
+	err := mainfunc()
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -30,3 +30,43 @@ type RoundTripper interface {
 	// RoundTrip sends a DNS query and receives the reply.
 	RoundTrip(query []byte) (reply []byte, err error)
 }
+
+// EndpointRoundTripper is a RoundTripper that also knows the network
+// and the address of the server. All the transports in this library
+// implement it, so that we can fill the DNSRoundTripEvent fields.
+type EndpointRoundTripper interface {
+	RoundTripper
+
+	// Network returns the transport network (e.g., "dot").
+	Network() string
+
+	// Address returns the address of the server (e.g., for "doh"
+	// this is the URL of the server).
+	Address() string
+}
+
+// ContextRoundTripper is a RoundTripper that also knows how to
+// use a context. All the transports in this library implement it.
+type ContextRoundTripper interface {
+	RoundTripper
+
+	// RoundTripContext is like RoundTrip but the context allows to
+	// interrupt a pending round trip at any time. If the context has
+	// a deadline, it overrides the transport's default timeout.
+	RoundTripContext(ctx context.Context, query []byte) (reply []byte, err error)
+}
+
+// RoundTripContext uses t.RoundTripContext when t is also a
+// ContextRoundTripper. Otherwise, it falls back to t.RoundTrip, in
+// which case the context is only checked before sending the query.
+func RoundTripContext(
+	ctx context.Context, t RoundTripper, query []byte,
+) ([]byte, error) {
+	if crt, ok := t.(ContextRoundTripper); ok {
+		return crt.RoundTripContext(ctx, query)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.RoundTrip(query)
+}
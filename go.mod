@@ -1,9 +1,18 @@
 module github.com/ooni/netx
 
-go 1.11
+go 1.24
 
 require (
 	github.com/m-lab/go v1.1.0
 	github.com/miekg/dns v1.1.17
-	golang.org/x/net v0.0.0-20190912160710-24e19bdeb0f2
+	github.com/refraction-networking/utls v1.8.2
+	golang.org/x/net v0.38.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.6 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	golang.org/x/crypto v0.36.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
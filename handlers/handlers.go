@@ -4,6 +4,11 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/m-lab/go/rtx"
 	"github.com/ooni/netx/model"
@@ -27,3 +32,299 @@ func (noHandler) OnMeasurement(m model.Measurement) {
 
 // NoHandler is a Handler that does not print anything
 var NoHandler noHandler
+
+// Collector is a Handler that saves all the measurements and allows
+// to query them. It is goroutine safe. The zero value is ready to use.
+type Collector struct {
+	measurements []model.Measurement
+	mutex        sync.Mutex
+}
+
+// OnMeasurement saves the measurement.
+func (c *Collector) OnMeasurement(m model.Measurement) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.measurements = append(c.measurements, m)
+}
+
+// Measurements returns all the measurements in the order in
+// which they were received.
+func (c *Collector) Measurements() []model.Measurement {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]model.Measurement{}, c.measurements...)
+}
+
+// ByConnID returns the measurements containing at least one event
+// with the specified ConnID. Since HTTP events also contain the
+// ConnID, this includes the HTTP events using such connection.
+func (c *Collector) ByConnID(connid int64) []model.Measurement {
+	return c.filter(func(m model.Measurement) bool {
+		return hasInt64Field(m, "ConnID", connid)
+	})
+}
+
+// ByTransactionID returns the measurements containing at least one
+// event with the specified TransactionID.
+func (c *Collector) ByTransactionID(txid int64) []model.Measurement {
+	return c.filter(func(m model.Measurement) bool {
+		return hasInt64Field(m, "TransactionID", txid)
+	})
+}
+
+// TLSHandshakes returns all the TLS handshake events.
+func (c *Collector) TLSHandshakes() (out []*model.TLSHandshakeEvent) {
+	for _, m := range c.Measurements() {
+		if m.TLSHandshake != nil {
+			out = append(out, m.TLSHandshake)
+		}
+	}
+	return
+}
+
+// DNSExchanges returns all the DNS round trip events, each of
+// which contains the query and the corresponding reply.
+func (c *Collector) DNSExchanges() (out []*model.DNSRoundTripEvent) {
+	for _, m := range c.Measurements() {
+		if m.DNSRoundTrip != nil {
+			out = append(out, m.DNSRoundTrip)
+		}
+	}
+	return
+}
+
+// ConnSummary summarizes what happened to a connection.
+type ConnSummary struct {
+	// BytesRead is the number of bytes read.
+	BytesRead int64
+
+	// BytesWritten is the number of bytes written.
+	BytesWritten int64
+
+	// CloseError is the error returned by Close, if any.
+	CloseError error
+
+	// CloseFailure is the classification of CloseError.
+	CloseFailure string
+
+	// Closed indicates whether the connection has been closed.
+	Closed bool
+
+	// ConnID is the connection ID.
+	ConnID int64
+
+	// FirstIO is the Time of the first read or write.
+	FirstIO time.Duration
+
+	// LastIO is the Time of the last read or write.
+	LastIO time.Duration
+
+	// LocalAddress is the local address, if known.
+	LocalAddress string
+
+	// Network is the network, if known.
+	Network string
+
+	// RemoteAddress is the remote address, if known.
+	RemoteAddress string
+}
+
+// Connections returns a summary of each connection for which we have
+// seen a successful connect or any I/O, sorted by ConnID.
+func (c *Collector) Connections() []ConnSummary {
+	summaries := make(map[int64]*ConnSummary)
+	get := func(connid int64) *ConnSummary {
+		summary, found := summaries[connid]
+		if !found {
+			summary = &ConnSummary{ConnID: connid}
+			summaries[connid] = summary
+		}
+		return summary
+	}
+	onIO := func(summary *ConnSummary, t time.Duration) {
+		if summary.FirstIO == 0 || t < summary.FirstIO {
+			summary.FirstIO = t
+		}
+		if t > summary.LastIO {
+			summary.LastIO = t
+		}
+	}
+	// With happy eyeballs there may be several successful connects
+	// with the same ConnID. We use the addresses of the first one that
+	// has not been discarded, i.e., of the connection we kept.
+	connects := make(map[int64][]*model.ConnectEvent)
+	discarded := make(map[[2]string]bool)
+	for _, m := range c.Measurements() {
+		if m.Connect != nil && m.Connect.Error == nil {
+			get(m.Connect.ConnID)
+			connects[m.Connect.ConnID] = append(
+				connects[m.Connect.ConnID], m.Connect,
+			)
+		}
+		if m.Read != nil {
+			summary := get(m.Read.ConnID)
+			summary.BytesRead += m.Read.NumBytes
+			onIO(summary, m.Read.Time)
+		}
+		if m.Write != nil {
+			summary := get(m.Write.ConnID)
+			summary.BytesWritten += m.Write.NumBytes
+			onIO(summary, m.Write.Time)
+		}
+		if m.Close != nil && m.Close.Failure == "discarded" {
+			discarded[[2]string{
+				m.Close.LocalAddress, m.Close.RemoteAddress,
+			}] = true
+		} else if m.Close != nil {
+			summary := get(m.Close.ConnID)
+			summary.CloseError = m.Close.Error
+			summary.CloseFailure = m.Close.Failure
+			summary.Closed = true
+		}
+	}
+	for connid, events := range connects {
+		for _, ev := range events {
+			if !discarded[[2]string{ev.LocalAddress, ev.RemoteAddress}] {
+				summary := summaries[connid]
+				summary.LocalAddress = ev.LocalAddress
+				summary.Network = ev.Network
+				summary.RemoteAddress = ev.RemoteAddress
+				break
+			}
+		}
+	}
+	var out []ConnSummary
+	for _, summary := range summaries {
+		out = append(out, *summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ConnID < out[j].ConnID
+	})
+	return out
+}
+
+func (c *Collector) filter(
+	f func(m model.Measurement) bool,
+) (out []model.Measurement) {
+	for _, m := range c.Measurements() {
+		if f(m) {
+			out = append(out, m)
+		}
+	}
+	return
+}
+
+// hasInt64Field returns true if any event in m has a field with
+// the specified name and value.
+func hasInt64Field(m model.Measurement, name string, value int64) bool {
+	mv := reflect.ValueOf(m)
+	for i := 0; i < mv.NumField(); i++ {
+		event := mv.Field(i)
+		if event.Kind() != reflect.Ptr || event.IsNil() {
+			continue
+		}
+		field := event.Elem().FieldByName(name)
+		if field.IsValid() && field.Kind() == reflect.Int64 &&
+			field.Int() == value {
+			return true
+		}
+	}
+	return false
+}
+
+type multiHandler []model.Handler
+
+func (h multiHandler) OnMeasurement(m model.Measurement) {
+	for _, handler := range h {
+		handler.OnMeasurement(m)
+	}
+}
+
+// Multi returns a Handler that passes each measurement to all the
+// specified handlers, in order.
+func Multi(handlers ...model.Handler) model.Handler {
+	return multiHandler(append([]model.Handler{}, handlers...))
+}
+
+type filterHandler struct {
+	handler   model.Handler
+	predicate func(model.Measurement) bool
+}
+
+func (h filterHandler) OnMeasurement(m model.Measurement) {
+	if h.predicate(m) {
+		h.handler.OnMeasurement(m)
+	}
+}
+
+// Filter returns a Handler that only passes to handler the
+// measurements for which predicate returns true.
+func Filter(
+	predicate func(model.Measurement) bool, handler model.Handler,
+) model.Handler {
+	return filterHandler{handler: handler, predicate: predicate}
+}
+
+// Async is a Handler that queues measurements and passes them to
+// another Handler in a background goroutine. Thus, a slow handler
+// does not slow down the code being measured. The queue is bounded
+// and we drop measurements when it is full. Use Dropped to know how
+// many measurements we have dropped. Make sure you call Close when
+// done, to deliver the queued measurements.
+type Async struct {
+	dropped int64 // first for 64-bit alignment on 32-bit archs
+	closed  bool
+	done    chan struct{}
+	mutex   sync.RWMutex
+	queue   chan model.Measurement
+}
+
+// NewAsync creates a new Async handler that passes measurements to
+// handler and can queue at most size measurements.
+func NewAsync(handler model.Handler, size int) *Async {
+	a := &Async{
+		done:  make(chan struct{}),
+		queue: make(chan model.Measurement, size),
+	}
+	go func() {
+		defer close(a.done)
+		for m := range a.queue {
+			handler.OnMeasurement(m)
+		}
+	}()
+	return a
+}
+
+// OnMeasurement queues the measurement without blocking. If the queue
+// is full, or we have been closed, we drop the measurement.
+func (a *Async) OnMeasurement(m model.Measurement) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.closed {
+		atomic.AddInt64(&a.dropped, 1)
+		return
+	}
+	select {
+	case a.queue <- m:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+// Dropped returns the number of measurements we have dropped so far.
+func (a *Async) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close stops accepting measurements and waits for the queued
+// measurements to be delivered. It is idempotent.
+func (a *Async) Close() error {
+	a.mutex.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mutex.Unlock()
+	<-a.done
+	return nil
+}
@@ -1,6 +1,8 @@
 package handlers_test
 
 import (
+	"errors"
+	"sync"
 	"testing"
 
 	"github.com/ooni/netx/handlers"
@@ -11,3 +13,203 @@ func TestIntegration(t *testing.T) {
 	handlers.NoHandler.OnMeasurement(model.Measurement{})
 	handlers.StdoutHandler.OnMeasurement(model.Measurement{})
 }
+
+func TestUnitCollector(t *testing.T) {
+	var collector handlers.Collector
+	closeError := errors.New("antani")
+	for _, m := range []model.Measurement{{
+		Connect: &model.ConnectEvent{
+			ConnID: 1,
+			Error:  errors.New("connection refused"),
+		},
+	}, {
+		Connect: &model.ConnectEvent{
+			ConnID:        1,
+			LocalAddress:  "127.0.0.1:54321",
+			Network:       "tcp",
+			RemoteAddress: "127.0.0.1:443",
+		},
+	}, {
+		TLSHandshake: &model.TLSHandshakeEvent{ConnID: 1},
+	}, {
+		HTTPRequestStart: &model.HTTPRequestStartEvent{
+			ConnID: 1, TransactionID: 7,
+		},
+	}, {
+		Write: &model.WriteEvent{ConnID: 1, NumBytes: 100, Time: 10},
+	}, {
+		Read: &model.ReadEvent{ConnID: 1, NumBytes: 1000, Time: 20},
+	}, {
+		Read: &model.ReadEvent{ConnID: 1, NumBytes: 24, Time: 30},
+	}, {
+		HTTPResponseDone: &model.HTTPResponseDoneEvent{
+			ConnID: 1, TransactionID: 7,
+		},
+	}, {
+		DNSQuery:     &model.DNSQueryEvent{ConnID: 2},
+		DNSReply:     &model.DNSReplyEvent{ConnID: 2},
+		DNSRoundTrip: &model.DNSRoundTripEvent{ConnID: 2},
+	}, {
+		Close: &model.CloseEvent{
+			ConnID: 1, Error: closeError, Failure: "unknown_failure: antani",
+		},
+	}} {
+		collector.OnMeasurement(m)
+	}
+	if len(collector.Measurements()) != 10 {
+		t.Fatal("unexpected number of measurements")
+	}
+	if len(collector.ByConnID(1)) != 9 {
+		t.Fatal("unexpected number of measurements for ConnID 1")
+	}
+	if len(collector.ByConnID(2)) != 1 {
+		t.Fatal("unexpected number of measurements for ConnID 2")
+	}
+	if len(collector.ByTransactionID(7)) != 2 {
+		t.Fatal("unexpected number of measurements for TransactionID 7")
+	}
+	if len(collector.TLSHandshakes()) != 1 {
+		t.Fatal("unexpected number of TLS handshakes")
+	}
+	if len(collector.DNSExchanges()) != 1 {
+		t.Fatal("unexpected number of DNS exchanges")
+	}
+	connections := collector.Connections()
+	if len(connections) != 1 {
+		t.Fatal("unexpected number of connections")
+	}
+	summary := connections[0]
+	if summary.ConnID != 1 || summary.RemoteAddress != "127.0.0.1:443" {
+		t.Fatal("unexpected connection")
+	}
+	if summary.BytesRead != 1024 || summary.BytesWritten != 100 {
+		t.Fatal("unexpected number of bytes")
+	}
+	if summary.FirstIO != 10 || summary.LastIO != 30 {
+		t.Fatal("unexpected I/O times")
+	}
+	if !summary.Closed || summary.CloseError != closeError {
+		t.Fatal("unexpected close state")
+	}
+}
+
+func TestUnitCollectorHappyEyeballs(t *testing.T) {
+	winner := &model.ConnectEvent{
+		ConnID:        1,
+		LocalAddress:  "127.0.0.1:54321",
+		Network:       "tcp",
+		RemoteAddress: "127.0.0.1:443",
+	}
+	loser := &model.ConnectEvent{
+		ConnID:        1,
+		LocalAddress:  "[::1]:54322",
+		Network:       "tcp",
+		RemoteAddress: "[::1]:443",
+	}
+	discard := &model.CloseEvent{
+		ConnID:        1,
+		Error:         model.ErrDiscarded,
+		Failure:       "discarded",
+		LocalAddress:  loser.LocalAddress,
+		RemoteAddress: loser.RemoteAddress,
+	}
+	// The loser may also succeed before the winner is chosen, so the
+	// order of the connects does not tell us which one we kept.
+	for _, connects := range [][]*model.ConnectEvent{
+		{winner, loser}, {loser, winner},
+	} {
+		var collector handlers.Collector
+		for _, ev := range connects {
+			collector.OnMeasurement(model.Measurement{Connect: ev})
+		}
+		collector.OnMeasurement(model.Measurement{Close: discard})
+		connections := collector.Connections()
+		if len(connections) != 1 {
+			t.Fatal("unexpected number of connections")
+		}
+		summary := connections[0]
+		if summary.LocalAddress != winner.LocalAddress ||
+			summary.RemoteAddress != winner.RemoteAddress {
+			t.Fatal("the summary uses the loser addresses")
+		}
+		if summary.Closed || summary.CloseFailure != "" {
+			t.Fatal("discarding the loser closed the winner")
+		}
+	}
+}
+
+func TestUnitCollectorConcurrent(t *testing.T) {
+	var (
+		collector handlers.Collector
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(connid int64) {
+			defer wg.Done()
+			collector.OnMeasurement(model.Measurement{
+				Read: &model.ReadEvent{ConnID: connid, NumBytes: 1},
+			})
+		}(int64(i))
+	}
+	wg.Wait()
+	if len(collector.Connections()) != 16 {
+		t.Fatal("unexpected number of connections")
+	}
+}
+
+func TestUnitMulti(t *testing.T) {
+	var first, second handlers.Collector
+	handler := handlers.Multi(&first, &second)
+	handler.OnMeasurement(model.Measurement{})
+	if len(first.Measurements()) != 1 || len(second.Measurements()) != 1 {
+		t.Fatal("expected both handlers to receive the measurement")
+	}
+}
+
+func TestUnitFilter(t *testing.T) {
+	var collector handlers.Collector
+	handler := handlers.Filter(func(m model.Measurement) bool {
+		return m.Read != nil
+	}, &collector)
+	handler.OnMeasurement(model.Measurement{Read: &model.ReadEvent{}})
+	handler.OnMeasurement(model.Measurement{Write: &model.WriteEvent{}})
+	measurements := collector.Measurements()
+	if len(measurements) != 1 || measurements[0].Read == nil {
+		t.Fatal("expected to only receive the read measurement")
+	}
+}
+
+type blockingHandler struct {
+	handlers.Collector
+	unblock chan struct{}
+}
+
+func (h *blockingHandler) OnMeasurement(m model.Measurement) {
+	<-h.unblock
+	h.Collector.OnMeasurement(m)
+}
+
+func TestUnitAsync(t *testing.T) {
+	handler := &blockingHandler{unblock: make(chan struct{})}
+	async := handlers.NewAsync(handler, 4)
+	// The background goroutine may dequeue the first measurement and
+	// block in OnMeasurement, so we can queue either 4 or 5 of them.
+	for i := 0; i < 10; i++ {
+		async.OnMeasurement(model.Measurement{})
+	}
+	dropped := async.Dropped()
+	if dropped != 5 && dropped != 6 {
+		t.Fatalf("unexpected number of dropped measurements: %d", dropped)
+	}
+	close(handler.unblock)
+	async.Close()
+	if int64(len(handler.Measurements()))+dropped != 10 {
+		t.Fatal("unexpected number of delivered measurements")
+	}
+	async.OnMeasurement(model.Measurement{})
+	if async.Dropped() != dropped+1 {
+		t.Fatal("expected measurements after Close to be dropped")
+	}
+	async.Close() // must be idempotent
+}
@@ -4,6 +4,7 @@
 package httpx
 
 import (
+	"io"
 	"net/http"
 	"time"
 
@@ -31,7 +32,10 @@ func NewTransport(beginning time.Time, handler model.Handler) *Transport {
 	// make sure HTTP uses our dialer
 	t.transport.Dial = t.dialer.Dial
 	t.transport.DialContext = t.dialer.DialContext
-	t.transport.DialTLS = t.dialer.DialTLS
+	t.transport.DialTLSContext = t.dialer.DialTLSContext
+	// make sure HTTP sees the front domains used by our dialer
+	t.dialer.FrontDomains = make(map[string]string)
+	t.transport.FrontDomains = t.dialer.FrontDomains
 	return t
 }
 
@@ -53,12 +57,122 @@ func (t *Transport) ConfigureDNS(network, address string) error {
 	return dnsconf.ConfigureDNS(t.dialer, network, address)
 }
 
+// SetDNSEngine is exactly like netx.Dialer.SetDNSEngine.
+func (t *Transport) SetDNSEngine(engine string) error {
+	return dnsconf.SetDNSEngine(t.dialer, engine)
+}
+
+// SetDNSDuplicatesWindow is exactly like
+// netx.Dialer.SetDNSDuplicatesWindow.
+func (t *Transport) SetDNSDuplicatesWindow(window time.Duration) error {
+	return dnsconf.SetDNSDuplicatesWindow(t.dialer, window)
+}
+
 // SetCABundle internally calls netx.Dialer.SetCABundle and
 // therefore it has the same caveats and limitations.
 func (t *Transport) SetCABundle(path string) error {
 	return t.dialer.SetCABundle(path)
 }
 
+// SetProxy is like netx.Dialer.SetProxy. When a proxy is configured,
+// we disable the HTTP proxy configured using environment variables.
+func (t *Transport) SetProxy(proxyURL string) error {
+	err := t.dialer.SetProxy(proxyURL)
+	if err != nil {
+		return err
+	}
+	if proxyURL != "" {
+		// Our dialer tunnels through the proxy, so the HTTP code
+		// must believe it is connecting directly.
+		t.transport.Proxy = nil
+	} else {
+		t.transport.Proxy = http.ProxyFromEnvironment
+	}
+	return nil
+}
+
+// SetHappyEyeballs is exactly like netx.Dialer.SetHappyEyeballs.
+func (t *Transport) SetHappyEyeballs(enabled bool) error {
+	return t.dialer.SetHappyEyeballs(enabled)
+}
+
+// SetCaptureData is exactly like netx.Dialer.SetCaptureData.
+func (t *Transport) SetCaptureData(maxPerEvent, maxPerConn int64) error {
+	return t.dialer.SetCaptureData(maxPerEvent, maxPerConn)
+}
+
+// SetKeyLogWriter is exactly like netx.Dialer.SetKeyLogWriter.
+func (t *Transport) SetKeyLogWriter(w io.Writer) error {
+	return t.dialer.SetKeyLogWriter(w)
+}
+
+// SetSeparateTLSVerification is exactly like
+// netx.Dialer.SetSeparateTLSVerification.
+func (t *Transport) SetSeparateTLSVerification(enabled bool) error {
+	return t.dialer.SetSeparateTLSVerification(enabled)
+}
+
+// SetTLSFingerprint is like netx.Dialer.SetTLSFingerprint. Because
+// net/http only speaks HTTP/2 over a *tls.Conn, when we mimic a browser
+// we only advertise HTTP/1.1 using ALPN.
+func (t *Transport) SetTLSFingerprint(fingerprint string) error {
+	err := t.dialer.SetTLSFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	if fingerprint != "" {
+		t.dialer.TLSConfig.NextProtos = []string{"http/1.1"}
+	} else {
+		t.dialer.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return nil
+}
+
+// SetTLSHandshaker is like netx.Dialer.SetTLSHandshaker. Because
+// net/http only speaks HTTP/2 over a *tls.Conn, unless the handshaker is
+// nil or the crypto/tls one, we only advertise HTTP/1.1 using ALPN.
+func (t *Transport) SetTLSHandshaker(handshaker model.TLSHandshaker) error {
+	err := t.dialer.SetTLSHandshaker(handshaker)
+	if err != nil {
+		return err
+	}
+	switch handshaker.(type) {
+	case nil, dialerapi.StdlibTLSHandshaker, *dialerapi.StdlibTLSHandshaker:
+		t.dialer.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+	default:
+		t.dialer.TLSConfig.NextProtos = []string{"http/1.1"}
+	}
+	return nil
+}
+
+// SetNoSNI is exactly like netx.Dialer.SetNoSNI.
+func (t *Transport) SetNoSNI(enabled bool) error {
+	return t.dialer.SetNoSNI(enabled)
+}
+
+// SetArbitrarySNI is exactly like netx.Dialer.SetArbitrarySNI.
+func (t *Transport) SetArbitrarySNI(sni string) error {
+	return t.dialer.SetArbitrarySNI(sni)
+}
+
+// SetECHConfigList is exactly like netx.Dialer.SetECHConfigList.
+func (t *Transport) SetECHConfigList(list []byte) error {
+	return t.dialer.SetECHConfigList(list)
+}
+
+// SetDomainFronting configures the transport to use domain fronting
+// when the URL host is host. When this happens, we connect to front,
+// we send front as the SNI, and we verify the certificate against front,
+// while the HTTP Host header is still host. The TLSHandshakeEvent and
+// the HTTPRequestHeadersDoneEvent contain both names. Domain fronting
+// only applies to "https" URLs. The empty front disables fronting host.
+// Unlike ForceSpecificSNI, this preserves certificate verification. This
+// function is not goroutine safe. Make sure you call it before starting
+// to use the transport.
+func (t *Transport) SetDomainFronting(host, front string) error {
+	return t.dialer.SetDomainFronting(host, front)
+}
+
 // ForceSpecificSNI forces using a specific SNI.
 func (t *Transport) ForceSpecificSNI(sni string) error {
 	return t.dialer.ForceSpecificSNI(sni)
@@ -92,12 +206,92 @@ func (c *Client) ConfigureDNS(network, address string) error {
 	return c.Transport.ConfigureDNS(network, address)
 }
 
+// SetDNSEngine internally calls netx.Dialer.SetDNSEngine and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetDNSEngine(engine string) error {
+	return c.Transport.SetDNSEngine(engine)
+}
+
+// SetDNSDuplicatesWindow internally calls
+// netx.Dialer.SetDNSDuplicatesWindow and therefore it has the same
+// caveats and limitations.
+func (c *Client) SetDNSDuplicatesWindow(window time.Duration) error {
+	return c.Transport.SetDNSDuplicatesWindow(window)
+}
+
 // SetCABundle internally calls netx.Dialer.SetCABundle and
 // therefore it has the same caveats and limitations.
 func (c *Client) SetCABundle(path string) error {
 	return c.Transport.SetCABundle(path)
 }
 
+// SetProxy internally calls netx.Dialer.SetProxy and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetProxy(proxyURL string) error {
+	return c.Transport.SetProxy(proxyURL)
+}
+
+// SetHappyEyeballs internally calls netx.Dialer.SetHappyEyeballs and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetHappyEyeballs(enabled bool) error {
+	return c.Transport.SetHappyEyeballs(enabled)
+}
+
+// SetCaptureData internally calls netx.Dialer.SetCaptureData and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetCaptureData(maxPerEvent, maxPerConn int64) error {
+	return c.Transport.SetCaptureData(maxPerEvent, maxPerConn)
+}
+
+// SetKeyLogWriter internally calls netx.Dialer.SetKeyLogWriter and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetKeyLogWriter(w io.Writer) error {
+	return c.Transport.SetKeyLogWriter(w)
+}
+
+// SetSeparateTLSVerification internally calls
+// netx.Dialer.SetSeparateTLSVerification and therefore it has the
+// same caveats and limitations.
+func (c *Client) SetSeparateTLSVerification(enabled bool) error {
+	return c.Transport.SetSeparateTLSVerification(enabled)
+}
+
+// SetTLSFingerprint internally calls Transport.SetTLSFingerprint and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetTLSFingerprint(fingerprint string) error {
+	return c.Transport.SetTLSFingerprint(fingerprint)
+}
+
+// SetTLSHandshaker internally calls Transport.SetTLSHandshaker and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetTLSHandshaker(handshaker model.TLSHandshaker) error {
+	return c.Transport.SetTLSHandshaker(handshaker)
+}
+
+// SetNoSNI internally calls netx.Dialer.SetNoSNI and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetNoSNI(enabled bool) error {
+	return c.Transport.SetNoSNI(enabled)
+}
+
+// SetArbitrarySNI internally calls netx.Dialer.SetArbitrarySNI and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetArbitrarySNI(sni string) error {
+	return c.Transport.SetArbitrarySNI(sni)
+}
+
+// SetECHConfigList internally calls netx.Dialer.SetECHConfigList and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetECHConfigList(list []byte) error {
+	return c.Transport.SetECHConfigList(list)
+}
+
+// SetDomainFronting internally calls Transport.SetDomainFronting and
+// therefore it has the same caveats and limitations.
+func (c *Client) SetDomainFronting(host, front string) error {
+	return c.Transport.SetDomainFronting(host, front)
+}
+
 // ForceSpecificSNI forces using a specific SNI.
 func (c *Client) ForceSpecificSNI(sni string) error {
 	return c.Transport.ForceSpecificSNI(sni)
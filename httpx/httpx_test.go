@@ -1,11 +1,22 @@
 package httpx_test
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/ooni/netx/handlers"
 	"github.com/ooni/netx/httpx"
+	"github.com/ooni/netx/internal/dialerapi"
+	"github.com/ooni/netx/model"
 )
 
 func TestIntegration(t *testing.T) {
@@ -51,3 +62,236 @@ func TestForceSpecificSNI(t *testing.T) {
 		t.Fatal("expected a nil response here")
 	}
 }
+
+func TestSetDNSEngine(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetDNSEngine("godns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetDNSEngine("antani")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetProxy("socks5://127.0.0.1:9050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetProxy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetProxy("ftp://127.0.0.1")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetCaptureData(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetCaptureData(1024, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetCaptureData(-1, 0)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetKeyLogWriter(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetKeyLogWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetSeparateTLSVerification(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetSeparateTLSVerification(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetTLSFingerprint(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetTLSFingerprint("chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetTLSFingerprint("antani")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+// trustServer configures the client to trust the server certificate.
+func trustServer(t *testing.T, client *httpx.Client, server *httptest.Server) {
+	dir, err := ioutil.TempDir("", "httpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cert.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw,
+	}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetCABundle(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitTLSFingerprintHTTP11(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	client := httpx.NewClient(handlers.NoHandler)
+	defer client.Transport.CloseIdleConnections()
+	trustServer(t, client, server)
+	if err := client.SetTLSFingerprint("chrome"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.HTTPClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 || resp.ProtoMajor != 1 {
+		t.Fatal("expected an HTTP/1.1 response")
+	}
+}
+
+func TestSetTLSHandshaker(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetTLSHandshaker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitTLSHandshakerHTTP11(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	for _, c := range []struct {
+		handshaker model.TLSHandshaker
+		protoMajor int
+	}{{
+		handshaker: wrappingHandshaker{},
+		protoMajor: 1,
+	}, {
+		handshaker: dialerapi.StdlibTLSHandshaker{},
+		protoMajor: 2,
+	}} {
+		client := httpx.NewClient(handlers.NoHandler)
+		trustServer(t, client, server)
+		if err := client.SetTLSHandshaker(c.handshaker); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.HTTPClient.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		client.Transport.CloseIdleConnections()
+		if resp.StatusCode != 404 || resp.ProtoMajor != c.protoMajor {
+			t.Fatalf("expected HTTP/%d", c.protoMajor)
+		}
+	}
+}
+
+// wrappingHandshaker uses crypto/tls but returns a conn that is
+// not a *tls.Conn, like most custom handshakers would do.
+type wrappingHandshaker struct{}
+
+func (wrappingHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	tc := tls.Client(conn, config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	return struct{ net.Conn }{tc}, tc.ConnectionState(), nil
+}
+
+func TestSNIHidingSetters(t *testing.T) {
+	client := httpx.NewClient(handlers.NoHandler)
+	err := client.SetNoSNI(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetArbitrarySNI("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetECHConfigList(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitDomainFronting(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Host))
+		},
+	))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	collector := &handlers.Collector{}
+	client := httpx.NewClient(collector)
+	defer client.Transport.CloseIdleConnections()
+	trustServer(t, client, server)
+	// The test certificate is valid for 127.0.0.1, so that is our front
+	err := client.SetDomainFronting("target.example.org", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := net.JoinHostPort("target.example.org", port)
+	resp, err := client.HTTPClient.Get("https://" + host + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != host || resp.ProtoMajor != 2 {
+		t.Fatal("expected the server to see the fronted host using h2")
+	}
+	handshakes := collector.TLSHandshakes()
+	if len(handshakes) != 1 {
+		t.Fatal("expected a single TLS handshake")
+	}
+	config := handshakes[0].Config
+	if config.ServerName != "127.0.0.1" || config.FrontedHost != "target.example.org" {
+		t.Fatal("expected both names in the TLSHandshakeEvent")
+	}
+	var found bool
+	for _, m := range collector.Measurements() {
+		if ev := m.HTTPRequestHeadersDone; ev != nil {
+			found = ev.FrontDomain == "127.0.0.1" &&
+				strings.Contains(ev.URL, "target.example.org")
+		}
+	}
+	if !found {
+		t.Fatal("expected both names in the HTTPRequestHeadersDoneEvent")
+	}
+}
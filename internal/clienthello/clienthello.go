@@ -0,0 +1,153 @@
+// Package clienthello parses the ClientHello we send, so that we can
+// record the parameters we have actually offered to the server, which
+// may differ from what is in the tls.Config (e.g., because the Go
+// TLS library applies its defaults).
+package clienthello
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/ooni/netx/model"
+)
+
+const (
+	extensionServerName          = 0
+	extensionSupportedGroups     = 10
+	extensionSignatureAlgorithms = 13
+	extensionALPN                = 16
+	extensionSupportedVersions   = 43
+	handshakeTypeClientHello     = 1
+	recordTypeHandshake          = 22
+)
+
+var errInvalid = errors.New("clienthello: invalid ClientHello")
+
+// reader reads big endian integers and length prefixed vectors.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = errInvalid
+		return nil
+	}
+	out := r.data[:n]
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *reader) uint(n int) (v int) {
+	for _, b := range r.next(n) {
+		v = v<<8 | int(b)
+	}
+	return
+}
+
+func (r *reader) vector(lengthSize int) *reader {
+	length := r.uint(lengthSize)
+	return &reader{data: r.next(length), err: r.err}
+}
+
+func (r *reader) uint16s() (out []uint16) {
+	for len(r.data) > 0 && r.err == nil {
+		out = append(out, uint16(r.uint(2)))
+	}
+	if r.err != nil {
+		return nil
+	}
+	return
+}
+
+// Parse parses a TLS record containing a ClientHello. We only support
+// the case where the ClientHello fits into a single record.
+func Parse(record []byte) (*model.TLSClientHello, error) {
+	r := &reader{data: record}
+	if r.uint(1) != recordTypeHandshake {
+		return nil, errInvalid
+	}
+	r.next(2) // record version
+	r = r.vector(2)
+	if r.uint(1) != handshakeTypeClientHello {
+		return nil, errInvalid
+	}
+	r = r.vector(3)
+	hello := &model.TLSClientHello{Version: uint16(r.uint(2))}
+	r.next(32)  // random
+	r.vector(1) // session ID
+	hello.CipherSuites = r.vector(2).uint16s()
+	r.vector(1) // compression methods
+	extensions := r.vector(2)
+	for len(extensions.data) > 0 && extensions.err == nil {
+		extType := uint16(extensions.uint(2))
+		ext := extensions.vector(2)
+		hello.Extensions = append(hello.Extensions, extType)
+		switch extType {
+		case extensionServerName:
+			names := ext.vector(2)
+			for len(names.data) > 0 && names.err == nil {
+				nameType := names.uint(1)
+				name := names.vector(2)
+				if nameType == 0 { // host_name
+					hello.ServerName = string(name.data)
+				}
+			}
+		case extensionSupportedGroups:
+			hello.SupportedGroups = ext.vector(2).uint16s()
+		case extensionSignatureAlgorithms:
+			hello.SignatureAlgorithms = ext.vector(2).uint16s()
+		case extensionALPN:
+			protos := ext.vector(2)
+			for len(protos.data) > 0 && protos.err == nil {
+				hello.ALPN = append(hello.ALPN, string(protos.vector(1).data))
+			}
+		case extensionSupportedVersions:
+			hello.SupportedVersions = ext.vector(1).uint16s()
+		}
+	}
+	if r.err != nil || extensions.err != nil {
+		return nil, errInvalid
+	}
+	return hello, nil
+}
+
+// Recorder is a net.Conn that records the first TLS record written,
+// which contains the ClientHello when the conn is used by a TLS client.
+// The record may span several writes (e.g., when the handshaker splits
+// the ClientHello across several segments).
+type Recorder struct {
+	net.Conn
+	data  []byte
+	mutex sync.Mutex
+}
+
+// Write implements net.Conn.Write.
+func (c *Recorder) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	if !recordComplete(c.data) {
+		c.data = append(c.data, b...)
+	}
+	c.mutex.Unlock()
+	return c.Conn.Write(b)
+}
+
+// recordComplete returns whether data contains a full TLS record.
+func recordComplete(data []byte) bool {
+	return len(data) >= 5 && len(data) >= 5+(int(data[3])<<8|int(data[4]))
+}
+
+// ClientHello returns the parsed ClientHello, or nil if we have not
+// seen any ClientHello or we cannot parse it.
+func (c *Recorder) ClientHello() *model.TLSClientHello {
+	c.mutex.Lock()
+	data := c.data
+	c.mutex.Unlock()
+	hello, err := Parse(data)
+	if err != nil {
+		return nil
+	}
+	return hello
+}
@@ -0,0 +1,68 @@
+package clienthello_test
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/ooni/netx/internal/clienthello"
+)
+
+func TestUnitRecorder(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		// Read the ClientHello and then fail the handshake
+		io.CopyN(ioutil.Discard, server, 5)
+		server.Close()
+	}()
+	recorder := &clienthello.Recorder{Conn: client}
+	if recorder.ClientHello() != nil {
+		t.Fatal("expected no ClientHello here")
+	}
+	tc := tls.Client(recorder, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		ServerName: "ooni.io",
+	})
+	if err := tc.Handshake(); err == nil {
+		t.Fatal("expected an error here")
+	}
+	hello := recorder.ClientHello()
+	if hello == nil {
+		t.Fatal("expected a ClientHello here")
+	}
+	if hello.ServerName != "ooni.io" {
+		t.Fatal("unexpected ServerName")
+	}
+	if len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" || hello.ALPN[1] != "http/1.1" {
+		t.Fatal("unexpected ALPN")
+	}
+	if hello.Version != tls.VersionTLS12 {
+		t.Fatal("unexpected legacy version")
+	}
+	var foundTLS13 bool
+	for _, version := range hello.SupportedVersions {
+		foundTLS13 = foundTLS13 || version == tls.VersionTLS13
+	}
+	if !foundTLS13 {
+		t.Fatal("expected TLS 1.3 to be supported")
+	}
+	if len(hello.CipherSuites) < 1 || len(hello.SupportedGroups) < 1 ||
+		len(hello.SignatureAlgorithms) < 1 || len(hello.Extensions) < 5 {
+		t.Fatal("expected more ClientHello parameters")
+	}
+}
+
+func TestUnitParseInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{23, 3, 3, 0, 0},                // not an handshake record
+		{22, 3, 1, 0, 4, 2, 0, 0},       // not a ClientHello
+		{22, 3, 1, 0, 5, 1, 0, 0, 1, 3}, // truncated ClientHello
+	} {
+		if _, err := clienthello.Parse(data); err == nil {
+			t.Fatal("expected an error here")
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Package connmap contains a goroutine safe registry mapping the
+// local and remote addresses of a connection to its ConnID.
+//
+// We cannot wrap a *tls.Conn with a ConnID-aware replacement because
+// that would confuse net/http and prevent using http2. So, the dialer
+// registers each connection it creates here, and the HTTP code uses
+// the addresses of the connection it is using to find the ConnID.
+package connmap
+
+import "sync"
+
+var (
+	mutex    sync.Mutex
+	registry = make(map[string]int64)
+)
+
+func makeKey(local, remote string) string {
+	return local + " " + remote
+}
+
+// Register registers the connection identified by the local and
+// remote addresses as having the specified ConnID. Registering again
+// the same addresses overrides the previous entry, which is what we
+// want when the OS reuses a port.
+func Register(local, remote string, connid int64) {
+	mutex.Lock()
+	registry[makeKey(local, remote)] = connid
+	mutex.Unlock()
+}
+
+// Unregister removes the connection identified by the local and
+// remote addresses from the registry. We only remove the entry if
+// it still refers to the specified ConnID, so that closing an old
+// connection does not remove a newer one using the same addresses.
+func Unregister(local, remote string, connid int64) {
+	mutex.Lock()
+	key := makeKey(local, remote)
+	if registry[key] == connid {
+		delete(registry, key)
+	}
+	mutex.Unlock()
+}
+
+// Lookup returns the ConnID of the connection identified by the
+// local and remote addresses. The returned bool is false if we
+// don't know of any such connection.
+func Lookup(local, remote string) (connid int64, found bool) {
+	mutex.Lock()
+	connid, found = registry[makeKey(local, remote)]
+	mutex.Unlock()
+	return
+}
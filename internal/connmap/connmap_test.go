@@ -0,0 +1,28 @@
+package connmap_test
+
+import (
+	"testing"
+
+	"github.com/ooni/netx/internal/connmap"
+)
+
+func TestRegisterLookupUnregister(t *testing.T) {
+	const local, remote = "10.0.0.1:54321", "8.8.8.8:443"
+	connmap.Register(local, remote, 17)
+	id, found := connmap.Lookup(local, remote)
+	if !found || id != 17 {
+		t.Fatal("expected to find the registered ConnID")
+	}
+	// A new connection reusing the same addresses wins
+	connmap.Register(local, remote, 18)
+	// Closing the old connection must not remove the new one
+	connmap.Unregister(local, remote, 17)
+	id, found = connmap.Lookup(local, remote)
+	if !found || id != 18 {
+		t.Fatal("expected to find the newer ConnID")
+	}
+	connmap.Unregister(local, remote, 18)
+	if _, found = connmap.Lookup(local, remote); found {
+		t.Fatal("expected the ConnID to be gone")
+	}
+}
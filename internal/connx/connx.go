@@ -3,18 +3,29 @@ package connx
 
 import (
 	"net"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/ooni/netx/internal/connmap"
+	"github.com/ooni/netx/internal/errclass"
 	"github.com/ooni/netx/model"
 )
 
-// MeasuringConn is a net.Conn used to perform measurements
+// MeasuringConn is a net.Conn used to perform measurements. When
+// MaxDataPerEvent is positive, we attach to each Read and Write event
+// at most MaxDataPerEvent bytes of payload. When MaxDataPerConn is also
+// positive, we stop attaching payload to events after we've attached
+// MaxDataPerConn bytes to Read events, and likewise for Write events.
 type MeasuringConn struct {
+	readCaptured  int64 // first for 64-bit alignment on 32-bit archs
+	writeCaptured int64
 	net.Conn
-	Beginning time.Time
-	Handler   model.Handler
-	ID        int64
+	Beginning       time.Time
+	Handler         model.Handler
+	ID              int64
+	MaxDataPerConn  int64
+	MaxDataPerEvent int64
 }
 
 // Read reads data from the connection.
@@ -24,8 +35,10 @@ func (c *MeasuringConn) Read(b []byte) (n int, err error) {
 	stop := time.Now()
 	c.Handler.OnMeasurement(model.Measurement{
 		Read: &model.ReadEvent{
+			Data:     c.capture(b[:n], &c.readCaptured),
 			Duration: stop.Sub(start),
 			Error:    err,
+			Failure:  errclass.Classify(err),
 			NumBytes: int64(n),
 			ConnID:   c.ID,
 			Time:     stop.Sub(c.Beginning),
@@ -41,8 +54,10 @@ func (c *MeasuringConn) Write(b []byte) (n int, err error) {
 	stop := time.Now()
 	c.Handler.OnMeasurement(model.Measurement{
 		Write: &model.WriteEvent{
+			Data:     c.capture(b[:n], &c.writeCaptured),
 			Duration: stop.Sub(start),
 			Error:    err,
+			Failure:  errclass.Classify(err),
 			NumBytes: int64(n),
 			ConnID:   c.ID,
 			Time:     stop.Sub(c.Beginning),
@@ -51,33 +66,83 @@ func (c *MeasuringConn) Write(b []byte) (n int, err error) {
 	return
 }
 
+// capture returns a copy of the payload to attach to an event, if
+// any, honouring the limits. The counter is the number of bytes we
+// have already attached to events of the same kind.
+func (c *MeasuringConn) capture(b []byte, counter *int64) []byte {
+	if c.MaxDataPerEvent <= 0 || len(b) <= 0 {
+		return nil
+	}
+	n := int64(len(b))
+	if n > c.MaxDataPerEvent {
+		n = c.MaxDataPerEvent
+	}
+	for c.MaxDataPerConn > 0 {
+		captured := atomic.LoadInt64(counter)
+		if captured >= c.MaxDataPerConn {
+			return nil
+		}
+		if n > c.MaxDataPerConn-captured {
+			n = c.MaxDataPerConn - captured
+		}
+		if atomic.CompareAndSwapInt64(counter, captured, captured+n) {
+			break
+		}
+	}
+	return append([]byte{}, b[:n]...)
+}
+
 // Close closes the connection
 func (c *MeasuringConn) Close() (err error) {
 	start := time.Now()
 	err = c.Conn.Close()
-	stop := time.Now()
+	c.emitClose(start, time.Now(), err)
+	return
+}
+
+// Discard is like Close except that the CloseEvent has ErrDiscarded
+// as the Error. We use it to close the connections that lost the
+// happy eyeballs race, which share the ConnID with the winner.
+func (c *MeasuringConn) Discard() {
+	start := time.Now()
+	c.Conn.Close()
+	c.emitClose(start, time.Now(), model.ErrDiscarded)
+}
+
+func (c *MeasuringConn) emitClose(start, stop time.Time, err error) {
+	var localAddress, remoteAddress string
+	if c.Conn.LocalAddr() != nil && c.Conn.RemoteAddr() != nil {
+		localAddress = c.Conn.LocalAddr().String()
+		remoteAddress = c.Conn.RemoteAddr().String()
+		connmap.Unregister(localAddress, remoteAddress, c.ID)
+	}
 	c.Handler.OnMeasurement(model.Measurement{
 		Close: &model.CloseEvent{
-			Duration: stop.Sub(start),
-			Error:    err,
-			ConnID:   c.ID,
-			Time:     stop.Sub(c.Beginning),
+			Duration:      stop.Sub(start),
+			Error:         err,
+			Failure:       errclass.Classify(err),
+			ConnID:        c.ID,
+			LocalAddress:  localAddress,
+			RemoteAddress: remoteAddress,
+			Time:          stop.Sub(c.Beginning),
 		},
 	})
-	return
 }
 
 // DNSMeasuringConn is like MeasuringConn except that it also
 // implements the net.PacketConn interface. This is required
-// to convince the Go resolver that this is an UDP connection.
+// to convince the Go resolver that this is an UDP connection. When
+// NoReplyEvents is true, we don't emit a DNSReplyEvent when reading,
+// because the underlying DNS transport does that already.
 type DNSMeasuringConn struct {
 	MeasuringConn
+	NoReplyEvents bool
 }
 
 // Read reads data from the connection.
 func (c *DNSMeasuringConn) Read(b []byte) (n int, err error) {
 	n, err = c.MeasuringConn.Read(b)
-	if err == nil {
+	if err == nil && !c.NoReplyEvents {
 		c.MeasuringConn.Handler.OnMeasurement(model.Measurement{
 			DNSReply: &model.DNSReplyEvent{
 				ConnID: c.MeasuringConn.ID,
@@ -100,3 +100,56 @@ func (fakeconn) SetReadDeadline(t time.Time) (err error) {
 func (fakeconn) SetWriteDeadline(t time.Time) (err error) {
 	return
 }
+
+func TestUnitMeasuringConnCaptureData(t *testing.T) {
+	var collector handlers.Collector
+	conn := net.Conn(&connx.MeasuringConn{
+		Conn:            fakeconn{},
+		Handler:         &collector,
+		MaxDataPerConn:  10,
+		MaxDataPerEvent: 4,
+	})
+	defer conn.Close()
+	data := []byte("abcdefgh")
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data[0] = 'X' // make sure the events contain a copy
+	var reads, writes []string
+	for _, m := range collector.Measurements() {
+		if m.Read != nil {
+			reads = append(reads, string(m.Read.Data))
+		}
+		if m.Write != nil {
+			writes = append(writes, string(m.Write.Data))
+		}
+	}
+	expected := []string{"abcd", "abcd", "ab"}
+	for i := range expected {
+		if reads[i] != expected[i] || writes[i] != expected[i] {
+			t.Fatal("unexpected captured data")
+		}
+	}
+}
+
+func TestUnitMeasuringConnNoCaptureData(t *testing.T) {
+	var collector handlers.Collector
+	conn := net.Conn(&connx.MeasuringConn{
+		Conn:    fakeconn{},
+		Handler: &collector,
+	})
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 128)); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range collector.Measurements() {
+		if m.Read != nil && m.Read.Data != nil {
+			t.Fatal("expected no captured data")
+		}
+	}
+}
@@ -7,13 +7,21 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/url"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/ooni/netx/internal/clienthello"
 	"github.com/ooni/netx/internal/connx"
 	"github.com/ooni/netx/internal/dialerbase"
+	"github.com/ooni/netx/internal/errclass"
+	"github.com/ooni/netx/internal/proxyhandshake"
+	"github.com/ooni/netx/internal/utlsx"
 	"github.com/ooni/netx/model"
 )
 
@@ -34,16 +42,44 @@ type DialHostPortFunc func(
 	ctx context.Context, network, onlyhost, onlyport string, connid int64,
 ) (*connx.MeasuringConn, error)
 
+// StdlibTLSHandshaker is the model.TLSHandshaker using crypto/tls.
+type StdlibTLSHandshaker struct{}
+
+// Handshake implements model.TLSHandshaker.Handshake.
+func (StdlibTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	tc := tls.Client(conn, config)
+	err := tc.HandshakeContext(ctx)
+	state := tc.ConnectionState()
+	if err != nil {
+		return nil, state, err
+	}
+	return tc, state, nil
+}
+
 // Dialer defines the dialer API. We implement the most basic form
 // of DNS, but more advanced resolutions are possible.
 type Dialer struct {
 	dialerbase.Dialer
-	DialHostPort          DialHostPortFunc
-	Handler               model.Handler
-	LookupHost            LookupHostFunc
-	StartTLSHandshakeHook func(net.Conn)
-	TLSConfig             *tls.Config
-	TLSHandshakeTimeout   time.Duration
+	ArbitrarySNI            string
+	DialHostPort            DialHostPortFunc
+	DNSDuplicatesWindow     time.Duration
+	DNSEngine               string
+	FrontDomains            map[string]string
+	Handler                 model.Handler
+	HappyEyeballs           bool
+	HappyEyeballsDelay      time.Duration
+	KeyLogWriter            io.Writer
+	LookupHost              LookupHostFunc
+	NoSNI                   bool
+	ProxyURL                *url.URL
+	SeparateTLSVerification bool
+	StartTLSHandshakeHook   func(net.Conn)
+	TLSConfig               *tls.Config
+	TLSFingerprint          string
+	TLSHandshakeTimeout     time.Duration
+	TLSHandshaker           model.TLSHandshaker
 }
 
 // NewDialer creates a new Dialer.
@@ -57,6 +93,7 @@ func NewDialer(beginning time.Time, handler model.Handler) (d *Dialer) {
 		Handler:               handler,
 		TLSConfig:             &tls.Config{},
 		StartTLSHandshakeHook: func(net.Conn) {},
+		TLSHandshaker:         StdlibTLSHandshaker{},
 	}
 	// This is equivalent to ConfigureDNS("system", "...")
 	r := &net.Resolver{
@@ -88,7 +125,23 @@ func (d *Dialer) DialContext(
 
 // DialTLS is like Dial, but creates TLS connections.
 func (d *Dialer) DialTLS(network, address string) (net.Conn, error) {
-	ctx := context.Background()
+	return d.DialTLSContext(context.Background(), network, address)
+}
+
+// DialTLSContext is like DialTLS but the context allows to interrupt
+// connecting and the TLS handshake at any time. The TLSHandshakeTimeout
+// still applies, regardless of the context.
+func (d *Dialer) DialTLSContext(
+	ctx context.Context, network, address string,
+) (net.Conn, error) {
+	// With domain fronting, we connect to the front domain, which is
+	// also the name we use for the SNI and to verify the certificate.
+	var frontedHost string
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if front := d.FrontDomains[host]; front != "" {
+			frontedHost, address = host, net.JoinHostPort(front, port)
+		}
+	}
 	conn, onlyhost, _, err := d.DialContextEx(ctx, network, address, false)
 	if err != nil {
 		return nil, err
@@ -97,22 +150,28 @@ func (d *Dialer) DialTLS(network, address string) (net.Conn, error) {
 	if config.ServerName == "" {
 		config.ServerName = onlyhost
 	}
+	if d.KeyLogWriter != nil {
+		config.KeyLogWriter = &keyLogWriter{conn: conn, dialer: d}
+	}
 	timeout := d.TLSHandshakeTimeout
 	if timeout <= 0 {
 		timeout = 10 * time.Second
 	}
-	tc, err := d.tlsHandshake(config, timeout, conn)
+	tc, err := d.tlsHandshake(ctx, config, frontedHost, timeout, conn)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 	// Note that we cannot wrap `tc` because the HTTP code assumes
-	// a `*tls.Conn` when implementing ALPN.
+	// a `*tls.Conn` when implementing ALPN. For the same reason, we
+	// only get HTTP/2 when using the crypto/tls handshaker.
 	return tc, nil
 }
 
 // DialContextEx is an extended DialContext where we may also
-// optionally prevent processing of domain names.
+// optionally prevent processing of domain names. When a proxy is
+// configured, we connect to the proxy and ask it to connect to the
+// address, and the returned conn is tunneled through the proxy.
 func (d *Dialer) DialContextEx(
 	ctx context.Context, network, address string, requireIP bool,
 ) (conn *connx.MeasuringConn, onlyhost, onlyport string, err error) {
@@ -121,12 +180,23 @@ func (d *Dialer) DialContextEx(
 		return
 	}
 	connid := NextConnID()
-	if net.ParseIP(onlyhost) != nil {
-		conn, err = d.DialHostPort(ctx, network, onlyhost, onlyport, connid)
+	if requireIP == true && net.ParseIP(onlyhost) == nil {
+		err = errors.New("dialerapi: you passed me a domain name")
 		return
 	}
-	if requireIP == true {
-		err = errors.New("dialerapi: you passed me a domain name")
+	if d.ProxyURL != nil && strings.HasPrefix(network, "tcp") {
+		conn, err = d.dialProxy(ctx, network, address, connid)
+		return
+	}
+	conn, err = d.dialHostPort(ctx, network, onlyhost, onlyport, connid)
+	return
+}
+
+func (d *Dialer) dialHostPort(
+	ctx context.Context, network, onlyhost, onlyport string, connid int64,
+) (conn *connx.MeasuringConn, err error) {
+	if net.ParseIP(onlyhost) != nil {
+		conn, err = d.DialHostPort(ctx, network, onlyhost, onlyport, connid)
 		return
 	}
 	start := time.Now()
@@ -139,25 +209,286 @@ func (d *Dialer) DialContextEx(
 			ConnID:    connid,
 			Duration:  stop.Sub(start),
 			Error:     err,
+			Failure:   errclass.Classify(err),
 			Hostname:  onlyhost,
 			Time:      stop.Sub(d.Beginning),
 		},
 	})
-	if err != nil {
-		return
+	var attempts []model.DialAttempt
+	if err == nil {
+		if d.HappyEyeballs {
+			conn, attempts = d.happyEyeballs(ctx, network, addrs, onlyport, connid)
+		} else {
+			conn, attempts = d.sequential(ctx, network, addrs, onlyport, connid)
+		}
+		if conn == nil {
+			err = &model.DialError{
+				Attempts: attempts,
+				Hostname: onlyhost,
+				Network:  network,
+			}
+		}
+	}
+	stop = time.Now()
+	var remoteAddress string
+	if conn != nil {
+		remoteAddress = conn.RemoteAddr().String()
+	}
+	d.Handler.OnMeasurement(model.Measurement{
+		Dial: &model.DialEvent{
+			Attempts:      attempts,
+			ConnID:        connid,
+			Duration:      stop.Sub(start),
+			Error:         err,
+			Failure:       errclass.Classify(err),
+			Hostname:      onlyhost,
+			Network:       network,
+			RemoteAddress: remoteAddress,
+			Time:          stop.Sub(d.Beginning),
+		},
+	})
+	return
+}
+
+// dialAttempt attempts to connect to a single address.
+func (d *Dialer) dialAttempt(
+	ctx context.Context, network, addr, onlyport string, connid int64,
+) (*connx.MeasuringConn, model.DialAttempt) {
+	start := time.Now()
+	conn, err := d.DialHostPort(ctx, network, addr, onlyport, connid)
+	return conn, model.DialAttempt{
+		Address:  net.JoinHostPort(addr, onlyport),
+		Duration: time.Now().Sub(start),
+		Error:    err,
+		Failure:  errclass.Classify(err),
 	}
+}
+
+// sequential tries to connect to each address in sequence and stops
+// at the first successful attempt.
+func (d *Dialer) sequential(
+	ctx context.Context, network string, addrs []string, onlyport string,
+	connid int64,
+) (*connx.MeasuringConn, []model.DialAttempt) {
+	var attempts []model.DialAttempt
 	for _, addr := range addrs {
-		conn, err = d.DialHostPort(ctx, network, addr, onlyport, connid)
-		if err == nil {
+		conn, attempt := d.dialAttempt(ctx, network, addr, onlyport, connid)
+		attempts = append(attempts, attempt)
+		if attempt.Error == nil {
+			return conn, attempts
+		}
+	}
+	return nil, attempts
+}
+
+// happyEyeballs races connect attempts as described in RFC 8305. We
+// start a new attempt every HappyEyeballsDelay, or as soon as the
+// previous attempt fails, and we stop at the first successful attempt,
+// cancelling all the others. We wait for all attempts to terminate
+// before returning, so each of them emits its ConnectEvent before we
+// return. All the attempts share the same ConnID. We close the attempts
+// that succeed after the winner using Discard, so they emit a CloseEvent
+// with ErrDiscarded. The returned attempts are in the order in which we
+// started them.
+func (d *Dialer) happyEyeballs(
+	ctx context.Context, network string, addrs []string, onlyport string,
+	connid int64,
+) (*connx.MeasuringConn, []model.DialAttempt) {
+	type result struct {
+		attempt model.DialAttempt
+		conn    *connx.MeasuringConn
+		index   int
+	}
+	if len(addrs) < 1 {
+		return nil, nil
+	}
+	delay := d.HappyEyeballsDelay
+	if delay <= 0 {
+		delay = 250 * time.Millisecond
+	}
+	addrs = InterleaveAddresses(addrs)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(addrs)) // so no-one blocks
+	var (
+		attempts []model.DialAttempt
+		next     int
+		pending  int
+		timer    <-chan time.Time
+		winner   *connx.MeasuringConn
+	)
+	collect := func(r result) {
+		attempts[r.index] = r.attempt
+		if r.attempt.Error != nil {
 			return
 		}
+		if winner != nil {
+			r.conn.Discard() // we already have a winner
+			return
+		}
+		winner = r.conn
 	}
-	err = &net.OpError{
-		Op:  "dial",
-		Net: network,
-		Err: errors.New("all connect attempts failed"),
+	startNext := func() {
+		addr, index := addrs[next], next
+		next++
+		pending++
+		attempts = append(attempts, model.DialAttempt{})
+		go func() {
+			conn, attempt := d.dialAttempt(ctx, network, addr, onlyport, connid)
+			results <- result{attempt: attempt, conn: conn, index: index}
+		}()
+		timer = nil
+		if next < len(addrs) {
+			timer = time.After(delay)
+		}
 	}
-	return
+	startNext()
+	for winner == nil && pending > 0 {
+		select {
+		case <-timer:
+			startNext()
+		case r := <-results:
+			pending--
+			collect(r)
+			if winner == nil && next < len(addrs) {
+				startNext()
+			}
+		}
+	}
+	cancel()
+	for ; pending > 0; pending-- {
+		collect(<-results)
+	}
+	return winner, attempts
+}
+
+// InterleaveAddresses reorders addresses so that IPv4 and IPv6 addresses
+// alternate, starting with the family of the first address, as recommended
+// by RFC 8305. You generally only care about this function when writing tests.
+func InterleaveAddresses(addrs []string) []string {
+	var first, second []string
+	isFirstFamily := func(addr string) bool {
+		return (net.ParseIP(addr).To4() == nil) == (net.ParseIP(addrs[0]).To4() == nil)
+	}
+	for _, addr := range addrs {
+		if isFirstFamily(addr) {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	var out []string
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			out, first = append(out, first[0]), first[1:]
+		}
+		if len(second) > 0 {
+			out, second = append(out, second[0]), second[1:]
+		}
+	}
+	return out
+}
+
+// dialProxy connects to the proxy and performs the handshake. Since
+// the proxy resolves the address, we do not emit any ResolveEvent
+// for it, except when the proxy itself is a domain name.
+func (d *Dialer) dialProxy(
+	ctx context.Context, network, address string, connid int64,
+) (*connx.MeasuringConn, error) {
+	proxyhost, proxyport := d.ProxyURL.Hostname(), d.ProxyURL.Port()
+	if proxyport == "" && d.ProxyURL.Scheme == "socks5" {
+		proxyport = "1080"
+	} else if proxyport == "" {
+		proxyport = "8080"
+	}
+	conn, err := d.dialHostPort(ctx, network, proxyhost, proxyport, connid)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok {
+		deadline = ctxDeadline
+	}
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The handshake code does not know about contexts, hence we unblock
+	// its pending I/O when the context is done, and we wait for the
+	// goroutine to exit, so it cannot set a deadline after we return.
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	start := time.Now()
+	if d.ProxyURL.Scheme == "socks5" {
+		err = proxyhandshake.SOCKS5(conn, address, d.ProxyURL.User)
+	} else {
+		err = proxyhandshake.HTTPConnect(conn, address, d.ProxyURL.User)
+	}
+	stop := time.Now()
+	close(done)
+	<-stopped
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err() // tells us why the I/O failed
+	}
+	d.Handler.OnMeasurement(model.Measurement{
+		ProxyHandshake: &model.ProxyHandshakeEvent{
+			ConnID:        connid,
+			Duration:      stop.Sub(start),
+			Error:         err,
+			Failure:       errclass.Classify(err),
+			ProxyAddress:  net.JoinHostPort(proxyhost, proxyport),
+			ProxyType:     d.ProxyURL.Scheme,
+			TargetAddress: address,
+			Time:          stop.Sub(d.Beginning),
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// See the comment at the end of tlsHandshake.
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// keyLogWriter emits a TLSKeyLogEvent for each line written by the
+// TLS code and forwards the line to the dialer's KeyLogWriter.
+type keyLogWriter struct {
+	conn   *connx.MeasuringConn
+	dialer *Dialer
+}
+
+func (w *keyLogWriter) Write(b []byte) (int, error) {
+	w.dialer.Handler.OnMeasurement(model.Measurement{
+		TLSKeyLog: &model.TLSKeyLogEvent{
+			ConnID: w.conn.ID,
+			Line:   strings.TrimSuffix(string(b), "\n"),
+			Time:   time.Now().Sub(w.conn.Beginning),
+		},
+	})
+	return w.dialer.KeyLogWriter.Write(b)
+}
+
+// lockedWriter serializes writes, since several handshakes may run in
+// parallel, possibly using distinct dialers sharing the same writer (e.g.
+// the dialers of the DoT and DoH transports, see dnsconf).
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *lockedWriter) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(b)
 }
 
 func (d *Dialer) clonedTLSConfig() *tls.Config {
@@ -165,40 +496,68 @@ func (d *Dialer) clonedTLSConfig() *tls.Config {
 }
 
 func (d *Dialer) tlsHandshake(
-	config *tls.Config, timeout time.Duration, conn *connx.MeasuringConn,
-) (*tls.Conn, error) {
+	ctx context.Context, config *tls.Config, frontedHost string,
+	timeout time.Duration, conn *connx.MeasuringConn,
+) (net.Conn, error) {
 	d.StartTLSHandshakeHook(conn)
 	err := conn.SetDeadline(time.Now().Add(timeout))
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
-	tc := tls.Client(net.Conn(conn), config)
+	// When verification is separate, we skip it during the handshake,
+	// so we always see the certificates, and we run it afterwards. We
+	// must also verify separately when we send a SNI different from the
+	// name against which we want to verify the certificate.
+	hideSNI := d.NoSNI || d.ArbitrarySNI != ""
+	separate := (d.SeparateTLSVerification || hideSNI) && !config.InsecureSkipVerify
+	handshakeConfig := config
+	if separate || hideSNI {
+		handshakeConfig = config.Clone()
+		handshakeConfig.InsecureSkipVerify = true
+	}
+	if hideSNI {
+		handshakeConfig.ServerName = d.ArbitrarySNI
+		if d.NoSNI {
+			handshakeConfig.ServerName = ""
+		}
+	}
+	recorder := &clienthello.Recorder{Conn: conn}
 	start := time.Now()
-	err = tc.Handshake()
+	tc, state, err := d.TLSHandshaker.Handshake(ctx, recorder, handshakeConfig)
+	if err == nil && separate {
+		state.VerifiedChains, err = verifyPeerCertificates(
+			config, state.PeerCertificates,
+		)
+		if err != nil {
+			err = &model.TLSVerificationError{Err: err}
+		}
+	}
 	stop := time.Now()
-	state := tc.ConnectionState()
 	d.Handler.OnMeasurement(model.Measurement{
 		TLSHandshake: &model.TLSHandshakeEvent{
 			Config: model.TLSConfig{
-				NextProtos: config.NextProtos,
-				ServerName: config.ServerName,
+				ECHConfigList: config.EncryptedClientHelloConfigList,
+				Fingerprint:   d.TLSFingerprint,
+				FrontedHost:   frontedHost,
+				NextProtos:    config.NextProtos,
+				SNI:           handshakeConfig.ServerName,
+				ServerName:    config.ServerName,
 			},
-			ConnectionState: model.TLSConnectionState{
-				CipherSuite:                state.CipherSuite,
-				NegotiatedProtocol:         state.NegotiatedProtocol,
-				NegotiatedProtocolIsMutual: state.NegotiatedProtocolIsMutual,
-				PeerCertificates:           simplifyCerts(state.PeerCertificates),
-				Version:                    state.Version,
-			},
-			Duration: stop.Sub(start),
-			Error:    err,
-			ConnID:   conn.ID,
-			Time:     stop.Sub(conn.Beginning),
+			ConnectionState: newConnectionState(state, recorder, err),
+			Duration:        stop.Sub(start),
+			Error:           err,
+			Failure:         errclass.Classify(err),
+			ConnID:          conn.ID,
+			Time:            stop.Sub(conn.Beginning),
 		},
 	})
 	if err != nil {
-		tc.Close()
+		// The handshaker returns a conn when the handshake succeeded
+		// and the separate verification failed.
+		if tc != nil {
+			tc.Close()
+		}
 		return nil, err
 	}
 	// The following call fails if the connection is not connected
@@ -209,6 +568,65 @@ func (d *Dialer) tlsHandshake(
 	return tc, nil
 }
 
+func newConnectionState(
+	state tls.ConnectionState, recorder *clienthello.Recorder, err error,
+) model.TLSConnectionState {
+	out := model.TLSConnectionState{
+		CipherSuite:                 state.CipherSuite,
+		DidResume:                   state.DidResume,
+		ECHAccepted:                 state.ECHAccepted,
+		NegotiatedProtocol:          state.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  state.NegotiatedProtocolIsMutual,
+		OCSPResponse:                state.OCSPResponse,
+		PeerCertificates:            simplifyCerts(state.PeerCertificates),
+		SignedCertificateTimestamps: state.SignedCertificateTimestamps,
+		Verified:                    len(state.VerifiedChains) > 0,
+		Version:                     state.Version,
+	}
+	for _, chain := range state.VerifiedChains {
+		out.VerifiedChains = append(out.VerifiedChains, simplifyCerts(chain))
+	}
+	if hello := recorder.ClientHello(); hello != nil {
+		out.ClientHello = *hello
+	}
+	var verificationError *tls.CertificateVerificationError
+	if errors.As(err, &verificationError) {
+		// The connection state does not contain the certificates
+		// when the verification fails, so take them from the error.
+		out.PeerCertificates = simplifyCerts(
+			verificationError.UnverifiedCertificates,
+		)
+		out.VerificationFailure = errclass.Classify(err)
+	}
+	var separateVerificationError *model.TLSVerificationError
+	if errors.As(err, &separateVerificationError) {
+		out.VerificationFailure = errclass.Classify(err)
+	}
+	return out
+}
+
+// verifyPeerCertificates verifies the certificates like crypto/tls
+// would do if we had not set InsecureSkipVerify.
+func verifyPeerCertificates(
+	config *tls.Config, certs []*x509.Certificate,
+) ([][]*x509.Certificate, error) {
+	if len(certs) < 1 {
+		return nil, errors.New("dialerapi: no peer certificates")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       config.ServerName,
+		Intermediates: x509.NewCertPool(),
+		Roots:         config.RootCAs,
+	}
+	if config.Time != nil {
+		opts.CurrentTime = config.Time()
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(opts)
+}
+
 func simplifyCerts(in []*x509.Certificate) (out []model.X509Certificate) {
 	for _, cert := range in {
 		out = append(out, model.X509Certificate{
@@ -230,6 +648,125 @@ func (d *Dialer) SetCABundle(path string) error {
 	return nil
 }
 
+// SetProxy configures the dialer to dial TCP connections through the
+// proxy with the specified URL. We support "socks5" and "http" (i.e.
+// HTTP CONNECT) proxies. The URL may contain username and password. The
+// empty string means that we should not use any proxy.
+func (d *Dialer) SetProxy(proxyURL string) error {
+	if proxyURL == "" {
+		d.ProxyURL = nil
+		return nil
+	}
+	URL, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	if URL.Scheme != "socks5" && URL.Scheme != "http" {
+		return errors.New("dialerapi: unsupported proxy scheme")
+	}
+	d.ProxyURL = URL
+	return nil
+}
+
+// SetHappyEyeballs enables or disables racing connect attempts
+// to the resolved addresses as described in RFC 8305.
+func (d *Dialer) SetHappyEyeballs(enabled bool) error {
+	d.HappyEyeballs = enabled
+	return nil
+}
+
+// SetCaptureData configures payload capture. See the documentation
+// of connx.MeasuringConn for the meaning of the arguments.
+func (d *Dialer) SetCaptureData(maxPerEvent, maxPerConn int64) error {
+	if maxPerEvent < 0 || maxPerConn < 0 {
+		return errors.New("dialerapi: negative capture limit")
+	}
+	d.Dialer.MaxDataPerEvent = maxPerEvent
+	d.Dialer.MaxDataPerConn = maxPerConn
+	return nil
+}
+
+// SetKeyLogWriter configures the writer where to write the TLS
+// secrets in NSS key log format. Passing nil disables key logging. We
+// wrap w so that writes are serialized, and the dialers to which you
+// copy KeyLogWriter share the same wrapper, hence the same lock.
+func (d *Dialer) SetKeyLogWriter(w io.Writer) error {
+	d.KeyLogWriter = nil
+	if w != nil {
+		d.KeyLogWriter = &lockedWriter{w: w}
+	}
+	return nil
+}
+
+// SetSeparateTLSVerification enables or disables verifying the
+// certificates after, rather than during, the TLS handshake.
+func (d *Dialer) SetSeparateTLSVerification(enabled bool) error {
+	d.SeparateTLSVerification = enabled
+	return nil
+}
+
+// SetTLSFingerprint selects the fingerprint of the ClientHello we
+// send. The empty string means that we use crypto/tls.
+func (d *Dialer) SetTLSFingerprint(fingerprint string) error {
+	if fingerprint == "" {
+		d.TLSFingerprint, d.TLSHandshaker = "", StdlibTLSHandshaker{}
+		return nil
+	}
+	handshaker, err := utlsx.NewHandshaker(fingerprint)
+	if err != nil {
+		return err
+	}
+	d.TLSFingerprint, d.TLSHandshaker = handshaker.Fingerprint(), handshaker
+	return nil
+}
+
+// SetTLSHandshaker sets the TLS handshaker. Since we don't know which
+// fingerprint the handshaker uses, we clear the TLSFingerprint. A nil
+// handshaker means that we use crypto/tls.
+func (d *Dialer) SetTLSHandshaker(handshaker model.TLSHandshaker) error {
+	if handshaker == nil {
+		handshaker = StdlibTLSHandshaker{}
+	}
+	d.TLSFingerprint, d.TLSHandshaker = "", handshaker
+	return nil
+}
+
+// SetNoSNI enables or disables sending no SNI. When enabled, we
+// still verify the certificate against the server name.
+func (d *Dialer) SetNoSNI(enabled bool) error {
+	d.NoSNI = enabled
+	return nil
+}
+
+// SetArbitrarySNI sets the SNI to send, while we still verify the
+// certificate against the server name. The empty string disables
+// sending an arbitrary SNI. SetNoSNI takes precedence.
+func (d *Dialer) SetArbitrarySNI(sni string) error {
+	d.ArbitrarySNI = sni
+	return nil
+}
+
+// SetDomainFronting configures DialTLS to connect to front when
+// the address host is host. The empty front disables fronting host.
+func (d *Dialer) SetDomainFronting(host, front string) error {
+	if front == "" {
+		delete(d.FrontDomains, host)
+		return nil
+	}
+	if d.FrontDomains == nil {
+		d.FrontDomains = make(map[string]string)
+	}
+	d.FrontDomains[host] = front
+	return nil
+}
+
+// SetECHConfigList sets the ECHConfigList to use Encrypted Client Hello.
+// A nil list disables Encrypted Client Hello.
+func (d *Dialer) SetECHConfigList(list []byte) error {
+	d.TLSConfig.EncryptedClientHelloConfigList = list
+	return nil
+}
+
 // ForceSpecificSNI forces using a specific SNI.
 func (d *Dialer) ForceSpecificSNI(sni string) error {
 	d.TLSConfig.ServerName = sni
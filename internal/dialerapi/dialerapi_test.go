@@ -1,15 +1,28 @@
 package dialerapi_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ooni/netx/handlers"
+	"github.com/ooni/netx/internal/connx"
 	"github.com/ooni/netx/internal/dialerapi"
+	"github.com/ooni/netx/internal/errclass"
+	"github.com/ooni/netx/model"
 )
 
 func TestIntegrationDial(t *testing.T) {
@@ -221,3 +234,1024 @@ func TestForceSpecificSNI(t *testing.T) {
 		t.Fatal("expected a nil connection here")
 	}
 }
+
+func TestUnitDialThroughProxy(t *testing.T) {
+	// The target is an echo server. The proxy implements CONNECT.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}
+	}()
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(502)
+				return
+			}
+			defer conn.Close()
+			w.WriteHeader(200)
+			client, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			go io.Copy(conn, client)
+			io.Copy(client, conn)
+		},
+	))
+	defer proxy.Close()
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	if err := dialer.SetProxy(proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("antani")); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 6)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "antani" {
+		t.Fatal("unexpected data")
+	}
+	var handshake *model.ProxyHandshakeEvent
+	for _, m := range handler.all() {
+		if m.ProxyHandshake != nil {
+			handshake = m.ProxyHandshake
+		}
+	}
+	if handshake == nil || handshake.Error != nil {
+		t.Fatal("expected a successful proxy handshake")
+	}
+	if handshake.ProxyType != "http" || handshake.TargetAddress != target.Addr().String() {
+		t.Fatal("unexpected proxy handshake fields")
+	}
+}
+
+func TestUnitDialThroughProxyFailure(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(403)
+		},
+	))
+	defer proxy.Close()
+	dialer := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+	if err := dialer.SetProxy(proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", "ooni.io:443")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if conn != nil {
+		t.Fatal("expected a nil conn here")
+	}
+}
+
+func TestUnitDialThroughProxyInterrupted(t *testing.T) {
+	// The proxy accepts and never replies, so the handshake blocks
+	address, closeServer := newBlackholeServer(t)
+	defer closeServer()
+	for _, c := range []struct {
+		expected string
+		scheme   string
+		timeout  bool
+	}{{
+		expected: "interrupted",
+		scheme:   "http",
+	}, {
+		expected: "interrupted",
+		scheme:   "socks5",
+	}, {
+		expected: "generic_timeout_error",
+		scheme:   "socks5",
+		timeout:  true,
+	}} {
+		handler := &savingHandler{}
+		dialer := dialerapi.NewDialer(time.Now(), handler)
+		if err := dialer.SetProxy(c.scheme + "://" + address); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.timeout {
+			ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		} else {
+			time.AfterFunc(100*time.Millisecond, cancel)
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", "ooni.io:443")
+		cancel()
+		if err == nil || conn != nil {
+			t.Fatal("expected an error here")
+		}
+		var ev *model.ProxyHandshakeEvent
+		for _, m := range handler.all() {
+			if m.ProxyHandshake != nil {
+				ev = m.ProxyHandshake
+			}
+		}
+		if ev == nil || ev.Failure != c.expected {
+			t.Fatalf("expected %s with %s", c.expected, c.scheme)
+		}
+		if ev.Duration >= 5*time.Second {
+			t.Fatal("expected the context to interrupt the handshake")
+		}
+	}
+}
+
+// newBlackholeServer returns the address of a server that accepts
+// connections and never replies, and a function that closes both the
+// listener and the accepted connections.
+func newBlackholeServer(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return listener.Addr().String(), func() {
+		listener.Close()
+		<-done // the goroutine does not touch conns anymore
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestUnitSetProxy(t *testing.T) {
+	dialer := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+	if err := dialer.SetProxy("socks5://127.0.0.1:9050"); err != nil {
+		t.Fatal(err)
+	}
+	if dialer.ProxyURL == nil {
+		t.Fatal("expected a proxy URL here")
+	}
+	if err := dialer.SetProxy(""); err != nil {
+		t.Fatal(err)
+	}
+	if dialer.ProxyURL != nil {
+		t.Fatal("expected no proxy URL here")
+	}
+	if err := dialer.SetProxy("ftp://127.0.0.1"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := dialer.SetProxy("\t"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+type savingHandler struct {
+	measurements []model.Measurement
+	mutex        sync.Mutex
+}
+
+func (h *savingHandler) OnMeasurement(m model.Measurement) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.measurements = append(h.measurements, m)
+}
+
+func (h *savingHandler) all() []model.Measurement {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.measurements
+}
+
+func TestUnitHappyEyeballs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.SetHappyEyeballs(true)
+	dialer.HappyEyeballsDelay = 50 * time.Millisecond
+	// Nobody is listening on the first address, so we should quickly
+	// fall back to the second address, which works.
+	dialer.LookupHost = func(context.Context, string) ([]string, error) {
+		return []string{"::1", "127.0.0.1"}, nil
+	}
+	start := time.Now()
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("antani.local", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if time.Now().Sub(start) > 2*time.Second {
+		t.Fatal("happy eyeballs took too much time")
+	}
+	var connects []*model.ConnectEvent
+	for _, m := range handler.all() {
+		if m.Connect != nil {
+			connects = append(connects, m.Connect)
+		}
+	}
+	if len(connects) < 2 {
+		t.Fatal("expected a ConnectEvent per attempt")
+	}
+	for _, ev := range connects {
+		if ev.ConnID != connects[0].ConnID {
+			t.Fatal("expected all attempts to share the ConnID")
+		}
+	}
+	var dial *model.DialEvent
+	for _, m := range handler.all() {
+		if m.Dial != nil {
+			dial = m.Dial
+		}
+	}
+	if dial == nil || dial.Error != nil || len(dial.Attempts) != len(connects) {
+		t.Fatal("unexpected DialEvent")
+	}
+	if dial.RemoteAddress != net.JoinHostPort("127.0.0.1", port) {
+		t.Fatal("unexpected winning address")
+	}
+}
+
+func TestUnitHappyEyeballsLoserSucceeds(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.SetHappyEyeballs(true)
+	dialer.HappyEyeballsDelay = 10 * time.Millisecond
+	dialer.LookupHost = func(context.Context, string) ([]string, error) {
+		return []string{"127.0.0.2", "127.0.0.1"}, nil
+	}
+	// The first attempt is slow and ignores cancellation, so it also
+	// succeeds, but only after the second attempt has won the race.
+	dialHostPort := dialer.DialHostPort
+	dialer.DialHostPort = func(
+		ctx context.Context, network, onlyhost, onlyport string, connid int64,
+	) (*connx.MeasuringConn, error) {
+		if onlyhost == "127.0.0.2" {
+			time.Sleep(200 * time.Millisecond)
+			ctx = context.Background()
+		}
+		return dialHostPort(ctx, network, "127.0.0.1", onlyport, connid)
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("antani.local", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closes := func() (events []*model.CloseEvent) {
+		for _, m := range handler.all() {
+			if m.Close != nil {
+				events = append(events, m.Close)
+			}
+		}
+		return
+	}
+	// The loser is closed with ErrDiscarded, and the CloseEvent has
+	// its addresses, so it can be told apart from the winner.
+	events := closes()
+	if len(events) != 1 || !errors.Is(events[0].Error, model.ErrDiscarded) {
+		t.Fatal("expected a CloseEvent for the loser")
+	}
+	if events[0].Failure != errclass.Discarded {
+		t.Fatal("unexpected Failure")
+	}
+	if events[0].LocalAddress == conn.LocalAddr().String() {
+		t.Fatal("the CloseEvent refers to the winner")
+	}
+	conn.Close()
+	events = closes()
+	if len(events) != 2 || events[1].Error != nil {
+		t.Fatal("expected a CloseEvent for the winner")
+	}
+	if events[1].LocalAddress != conn.LocalAddr().String() {
+		t.Fatal("the CloseEvent does not refer to the winner")
+	}
+	var connects int
+	for _, m := range handler.all() {
+		if m.Connect != nil && m.Connect.Error == nil {
+			connects++
+		}
+	}
+	if connects != 2 {
+		t.Fatal("expected both attempts to succeed")
+	}
+}
+
+func TestUnitHappyEyeballsAllFailed(t *testing.T) {
+	dialer := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+	dialer.SetHappyEyeballs(true)
+	dialer.LookupHost = func(context.Context, string) ([]string, error) {
+		return []string{"127.0.0.1", "::1"}, nil
+	}
+	// Port zero is not a valid destination port
+	conn, err := dialer.Dial("tcp", "antani.local:0")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if conn != nil {
+		t.Fatal("expected a nil conn here")
+	}
+}
+
+func TestUnitInterleaveAddresses(t *testing.T) {
+	out := dialerapi.InterleaveAddresses([]string{
+		"::1", "::2", "::3", "1.1.1.1", "1.1.1.2",
+	})
+	expected := []string{"::1", "1.1.1.1", "::2", "1.1.1.2", "::3"}
+	if len(out) != len(expected) {
+		t.Fatal("unexpected length")
+	}
+	for i := range out {
+		if out[i] != expected[i] {
+			t.Fatal("unexpected order")
+		}
+	}
+}
+
+func TestUnitDialError(t *testing.T) {
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.LookupHost = func(context.Context, string) ([]string, error) {
+		return []string{"127.0.0.1", "::1"}, nil
+	}
+	// Port zero is not a valid destination port
+	_, err := dialer.Dial("tcp", "antani.local:0")
+	dialError, ok := err.(*model.DialError)
+	if !ok {
+		t.Fatal("expected a *model.DialError here")
+	}
+	if dialError.Hostname != "antani.local" || dialError.Network != "tcp" {
+		t.Fatal("unexpected hostname or network")
+	}
+	if len(dialError.Attempts) != 2 {
+		t.Fatal("expected two attempts")
+	}
+	if dialError.Attempts[0].Address != "127.0.0.1:0" {
+		t.Fatal("unexpected first address")
+	}
+	if dialError.Attempts[1].Address != "[::1]:0" {
+		t.Fatal("unexpected second address")
+	}
+	for _, attempt := range dialError.Attempts {
+		if attempt.Error == nil || attempt.Failure == "" {
+			t.Fatal("expected an error and a failure here")
+		}
+	}
+	var dials []*model.DialEvent
+	for _, m := range handler.all() {
+		if m.Dial != nil {
+			dials = append(dials, m.Dial)
+		}
+	}
+	if len(dials) != 1 {
+		t.Fatal("expected a single DialEvent")
+	}
+	if dials[0].Error != err || len(dials[0].Attempts) != 2 {
+		t.Fatal("the DialEvent does not match the error")
+	}
+	if dials[0].RemoteAddress != "" {
+		t.Fatal("expected no remote address")
+	}
+}
+
+func TestUnitKeyLogWriter(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.TLSConfig.RootCAs = x509.NewCertPool()
+	dialer.TLSConfig.RootCAs.AddCert(server.Certificate())
+	keylog := new(bytes.Buffer)
+	if err := dialer.SetKeyLogWriter(keylog); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	var (
+		events    []*model.TLSKeyLogEvent
+		handshake *model.TLSHandshakeEvent
+		lines     []string
+	)
+	for _, m := range handler.all() {
+		if m.TLSHandshake != nil {
+			handshake = m.TLSHandshake
+		}
+		if m.TLSKeyLog != nil {
+			events = append(events, m.TLSKeyLog)
+			lines = append(lines, m.TLSKeyLog.Line+"\n")
+		}
+	}
+	if handshake == nil || len(events) < 1 {
+		t.Fatal("expected TLSHandshake and TLSKeyLog events")
+	}
+	for _, ev := range events {
+		if ev.ConnID != handshake.ConnID {
+			t.Fatal("unexpected ConnID")
+		}
+	}
+	if strings.Join(lines, "") != keylog.String() {
+		t.Fatal("the events do not match what we have written")
+	}
+	if !strings.HasPrefix(keylog.String(), "CLIENT_") {
+		t.Fatal("unexpected key log format")
+	}
+}
+
+func TestUnitKeyLogWriterShared(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	keylog := &overlapDetector{}
+	var dialers []*dialerapi.Dialer
+	for i := 0; i < 2; i++ {
+		dialer := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+		dialer.TLSConfig.RootCAs = x509.NewCertPool()
+		dialer.TLSConfig.RootCAs.AddCert(server.Certificate())
+		dialers = append(dialers, dialer)
+	}
+	// Both crypto/tls and uTLS serialize the writes to any KeyLogWriter
+	// using a global mutex, so writes may only overlap when using both.
+	if err := dialers[1].SetTLSFingerprint("chrome"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialers[0].SetKeyLogWriter(keylog); err != nil {
+		t.Fatal(err)
+	}
+	// This is what dnsconf does for the DoT and DoH dialers.
+	dialers[1].KeyLogWriter = dialers[0].KeyLogWriter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(dialer *dialerapi.Dialer) {
+			defer wg.Done()
+			conn, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+			if err == nil {
+				conn.Close()
+			}
+		}(dialers[i%2])
+	}
+	wg.Wait()
+	if atomic.LoadInt64(&keylog.overlaps) != 0 {
+		t.Fatal("concurrent writes to the key log writer")
+	}
+	if dialers[0].SetKeyLogWriter(nil); dialers[0].KeyLogWriter != nil {
+		t.Fatal("expected nil to disable key logging")
+	}
+}
+
+// overlapDetector counts the writes overlapping with other writes.
+type overlapDetector struct {
+	active   int64
+	overlaps int64
+}
+
+func (d *overlapDetector) Write(b []byte) (int, error) {
+	if atomic.AddInt64(&d.active, 1) > 1 {
+		atomic.AddInt64(&d.overlaps, 1)
+	}
+	time.Sleep(2 * time.Millisecond)
+	atomic.AddInt64(&d.active, -1)
+	return len(b), nil
+}
+
+func handshakeWithServer(
+	t *testing.T, server *httptest.Server, config *tls.Config, separate bool,
+) (*model.TLSHandshakeEvent, error) {
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.TLSConfig = config
+	dialer.SetSeparateTLSVerification(separate)
+	conn, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+	if err == nil {
+		conn.Close()
+	}
+	for _, m := range handler.all() {
+		if m.TLSHandshake != nil {
+			return m.TLSHandshake, err
+		}
+	}
+	t.Fatal("no TLSHandshake event")
+	return nil, err
+}
+
+func TestUnitTLSConnectionState(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	ev, err := handshakeWithServer(t, server, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		RootCAs:    roots,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := ev.ConnectionState
+	if !state.Verified || state.VerificationFailure != "" {
+		t.Fatal("expected the certificate to be verified")
+	}
+	if len(state.VerifiedChains) != 1 || len(state.PeerCertificates) != 1 {
+		t.Fatal("unexpected number of chains or certificates")
+	}
+	if state.DidResume {
+		t.Fatal("did not expect a resumed session")
+	}
+	if len(state.ClientHello.ALPN) != 2 || len(state.ClientHello.CipherSuites) < 1 {
+		t.Fatal("unexpected ClientHello")
+	}
+	if state.NegotiatedProtocol != "h2" {
+		t.Fatal("unexpected negotiated protocol")
+	}
+}
+
+func TestUnitTLSVerificationFailure(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	for _, c := range []struct {
+		config   *tls.Config
+		expected string
+	}{{
+		config:   &tls.Config{},
+		expected: "ssl_unknown_authority",
+	}, {
+		config:   &tls.Config{RootCAs: roots, ServerName: "ooni.io"},
+		expected: "ssl_invalid_hostname",
+	}} {
+		ev, err := handshakeWithServer(t, server, c.config, false)
+		if err == nil {
+			t.Fatal("expected an error here")
+		}
+		state := ev.ConnectionState
+		if state.Verified || state.VerificationFailure != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, state.VerificationFailure)
+		}
+		if ev.Failure != c.expected {
+			t.Fatal("expected the event failure to match")
+		}
+		if len(state.PeerCertificates) != 1 {
+			t.Fatal("expected the peer certificates anyway")
+		}
+	}
+}
+
+func TestUnitSeparateTLSVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	ev, err := handshakeWithServer(t, server, &tls.Config{RootCAs: roots}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := ev.ConnectionState
+	if !state.Verified || len(state.VerifiedChains) != 1 {
+		t.Fatal("expected the certificate to be verified")
+	}
+	for _, c := range []struct {
+		config   *tls.Config
+		expected string
+	}{{
+		config:   &tls.Config{},
+		expected: "ssl_unknown_authority",
+	}, {
+		config:   &tls.Config{RootCAs: roots, ServerName: "ooni.io"},
+		expected: "ssl_invalid_hostname",
+	}} {
+		ev, err := handshakeWithServer(t, server, c.config, true)
+		var verr *model.TLSVerificationError
+		if !errors.As(err, &verr) {
+			t.Fatal("expected a TLSVerificationError")
+		}
+		if ev.Error != err || ev.Failure != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, ev.Failure)
+		}
+		state := ev.ConnectionState
+		if state.Verified || state.VerificationFailure != c.expected {
+			t.Fatal("expected the verification failure to be recorded")
+		}
+		if len(state.PeerCertificates) != 1 || len(state.VerifiedChains) != 0 {
+			t.Fatal("unexpected certificates or chains")
+		}
+	}
+}
+
+func TestUnitTLSFingerprint(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.TLSConfig.RootCAs = x509.NewCertPool()
+	dialer.TLSConfig.RootCAs.AddCert(server.Certificate())
+	dialer.TLSConfig.ServerName = "example.com"
+	if err := dialer.SetTLSFingerprint("antani"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := dialer.SetTLSFingerprint("firefox"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, ok := conn.(*tls.Conn); ok {
+		t.Fatal("expected a conn not created by crypto/tls")
+	}
+	var ev *model.TLSHandshakeEvent
+	for _, m := range handler.all() {
+		if m.TLSHandshake != nil {
+			ev = m.TLSHandshake
+		}
+	}
+	if ev == nil || !strings.HasPrefix(ev.Config.Fingerprint, "Firefox-") {
+		t.Fatal("expected the fingerprint to be recorded")
+	}
+	if !ev.ConnectionState.Verified {
+		t.Fatal("expected the certificate to be verified")
+	}
+	if err := dialer.SetTLSFingerprint(""); err != nil {
+		t.Fatal(err)
+	}
+	if dialer.TLSFingerprint != "" {
+		t.Fatal("expected no fingerprint")
+	}
+}
+
+// splittingConn writes the first buffer in two segments.
+type splittingConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *splittingConn) Write(b []byte) (n int, err error) {
+	c.once.Do(func() {
+		if len(b) > 1 {
+			n, err = c.Conn.Write(b[:len(b)/2])
+			b = b[n:]
+		}
+	})
+	if err != nil {
+		return n, err
+	}
+	count, err := c.Conn.Write(b)
+	return n + count, err
+}
+
+type splittingHandshaker struct {
+	dialerapi.StdlibTLSHandshaker
+}
+
+func (h splittingHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	return h.StdlibTLSHandshaker.Handshake(ctx, &splittingConn{Conn: conn}, config)
+}
+
+func TestUnitTLSHandshaker(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.TLSConfig.RootCAs = x509.NewCertPool()
+	dialer.TLSConfig.RootCAs.AddCert(server.Certificate())
+	dialer.SetTLSFingerprint("chrome")
+	if err := dialer.SetTLSHandshaker(splittingHandshaker{}); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	var writes []int64
+	var ev *model.TLSHandshakeEvent
+	for _, m := range handler.all() {
+		if m.Write != nil && ev == nil {
+			writes = append(writes, m.Write.NumBytes)
+		}
+		if m.TLSHandshake != nil {
+			ev = m.TLSHandshake
+		}
+	}
+	if len(writes) < 2 || writes[0] >= writes[1] {
+		t.Fatal("expected the ClientHello to be split")
+	}
+	if ev.Config.Fingerprint != "" {
+		t.Fatal("expected no fingerprint")
+	}
+	if len(ev.ConnectionState.ClientHello.CipherSuites) < 1 {
+		t.Fatal("expected the split ClientHello to be recorded")
+	}
+}
+
+type failingHandshaker struct{}
+
+func (failingHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	return nil, tls.ConnectionState{}, io.ErrUnexpectedEOF
+}
+
+func TestUnitTLSHandshakerFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	dialer.SetTLSHandshaker(failingHandshaker{})
+	conn, err := dialer.DialTLS("tcp", listener.Addr().String())
+	if err != io.ErrUnexpectedEOF || conn != nil {
+		t.Fatal("expected the handshaker error")
+	}
+	var found bool
+	for _, m := range handler.all() {
+		if m.TLSHandshake != nil {
+			found = m.TLSHandshake.Error == io.ErrUnexpectedEOF
+		}
+		if m.Close != nil && !found {
+			t.Fatal("expected the conn to be closed after the event")
+		}
+	}
+	if !found {
+		t.Fatal("expected the error to be recorded")
+	}
+	dialer.SetTLSHandshaker(nil)
+	if _, ok := dialer.TLSHandshaker.(dialerapi.StdlibTLSHandshaker); !ok {
+		t.Fatal("expected the crypto/tls handshaker")
+	}
+}
+
+func TestUnitDialTLSContextInterrupted(t *testing.T) {
+	// The server accepts and never replies, so the handshake blocks
+	address, closeServer := newBlackholeServer(t)
+	defer closeServer()
+	for _, c := range []struct {
+		cancelAfter time.Duration
+		expected    string
+		timeout     bool
+	}{{
+		cancelAfter: 100 * time.Millisecond,
+		expected:    "interrupted",
+	}, {
+		cancelAfter: 100 * time.Millisecond,
+		expected:    "generic_timeout_error",
+		timeout:     true,
+	}} {
+		handler := &savingHandler{}
+		dialer := dialerapi.NewDialer(time.Now(), handler)
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.timeout {
+			ctx, cancel = context.WithTimeout(context.Background(), c.cancelAfter)
+		} else {
+			time.AfterFunc(c.cancelAfter, cancel)
+		}
+		conn, err := dialer.DialTLSContext(ctx, "tcp", address)
+		cancel()
+		if err == nil || conn != nil {
+			t.Fatal("expected an error here")
+		}
+		var ev *model.TLSHandshakeEvent
+		for _, m := range handler.all() {
+			if m.TLSHandshake != nil {
+				ev = m.TLSHandshake
+			}
+		}
+		if ev == nil || ev.Failure != c.expected {
+			t.Fatalf("expected %s", c.expected)
+		}
+		if ev.Duration >= 5*time.Second {
+			t.Fatal("expected the context to interrupt the handshake")
+		}
+	}
+}
+
+func TestUnitDialTLSContextCanceled(t *testing.T) {
+	handler := &savingHandler{}
+	dialer := dialerapi.NewDialer(time.Now(), handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn, err := dialer.DialTLSContext(ctx, "tcp", "127.0.0.1:443")
+	if err == nil || conn != nil {
+		t.Fatal("expected an error here")
+	}
+	for _, m := range handler.all() {
+		if m.TLSHandshake != nil {
+			t.Fatal("expected no handshake")
+		}
+		if m.Connect != nil && m.Connect.Failure != "interrupted" {
+			t.Fatal("expected the connect to be interrupted")
+		}
+	}
+}
+
+// newSNIServer returns a TLS server that saves the SNI it receives.
+func newSNIServer(config *tls.Config) (*httptest.Server, func() string) {
+	var (
+		mutex sync.Mutex
+		sni   string
+	)
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = config
+	server.TLS.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sni = hello.ServerName
+		return nil, nil
+	}
+	server.StartTLS()
+	return server, func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return sni
+	}
+}
+
+func dialTLSWithHandler(
+	t *testing.T, dialer *dialerapi.Dialer, handler *savingHandler,
+	server *httptest.Server,
+) (*model.TLSHandshakeEvent, error) {
+	dialer.TLSConfig.RootCAs = x509.NewCertPool()
+	dialer.TLSConfig.RootCAs.AddCert(server.Certificate())
+	conn, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+	if err == nil {
+		conn.Close()
+	}
+	for _, m := range handler.all() {
+		if m.TLSHandshake != nil {
+			return m.TLSHandshake, err
+		}
+	}
+	t.Fatal("no TLSHandshake event")
+	return nil, err
+}
+
+func TestUnitSNIHiding(t *testing.T) {
+	server, receivedSNI := newSNIServer(&tls.Config{})
+	defer server.Close()
+	for _, c := range []struct {
+		arbitrarySNI string
+		failure      string
+		noSNI        bool
+		serverName   string
+		sni          string
+	}{{
+		noSNI:      true,
+		serverName: "example.com",
+	}, {
+		arbitrarySNI: "ooni.org",
+		serverName:   "example.com",
+		sni:          "ooni.org",
+	}, {
+		arbitrarySNI: "example.com",
+		failure:      "ssl_invalid_hostname",
+		serverName:   "ooni.io",
+		sni:          "example.com",
+	}} {
+		handler := &savingHandler{}
+		dialer := dialerapi.NewDialer(time.Now(), handler)
+		dialer.ForceSpecificSNI(c.serverName)
+		dialer.SetNoSNI(c.noSNI)
+		dialer.SetArbitrarySNI(c.arbitrarySNI)
+		ev, err := dialTLSWithHandler(t, dialer, handler, server)
+		if ev.Failure != c.failure {
+			t.Fatalf("expected '%s', got '%s'", c.failure, ev.Failure)
+		}
+		var verr *model.TLSVerificationError
+		if c.failure != "" && !errors.As(err, &verr) {
+			t.Fatal("expected a TLSVerificationError")
+		}
+		if receivedSNI() != c.sni || ev.ConnectionState.ClientHello.ServerName != c.sni {
+			t.Fatal("the server did not receive the expected SNI")
+		}
+		if ev.Config.SNI != c.sni || ev.Config.ServerName != c.serverName {
+			t.Fatal("the event does not contain the expected names")
+		}
+		if len(ev.ConnectionState.PeerCertificates) != 1 {
+			t.Fatal("expected the peer certificates")
+		}
+	}
+}
+
+// newECHConfig returns an ECHConfig, the corresponding ECHConfigList,
+// and the private key, using DHKEM(X25519), HKDF-SHA256, and AES-128-GCM.
+func newECHConfig(t *testing.T, publicName string) ([]byte, []byte, []byte) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vector := func(b []byte) []byte {
+		return append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+	contents := []byte{1, 0x00, 0x20} // config_id, kem_id
+	contents = append(contents, vector(key.PublicKey().Bytes())...)
+	contents = append(contents, vector([]byte{0, 1, 0, 1})...) // kdf_id, aead_id
+	contents = append(contents, 0, byte(len(publicName)))      // maximum_name_length
+	contents = append(contents, publicName...)
+	contents = append(contents, vector(nil)...) // extensions
+	config := append([]byte{0xfe, 0x0d}, vector(contents)...)
+	return config, vector(config), key.Bytes()
+}
+
+func TestUnitECH(t *testing.T) {
+	config, list, key := newECHConfig(t, "public.example.com")
+	server, receivedSNI := newSNIServer(&tls.Config{
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config: config, PrivateKey: key, SendAsRetry: true,
+		}},
+	})
+	defer server.Close()
+	_, otherList, _ := newECHConfig(t, "public.example.com")
+	for _, fingerprint := range []string{"", "chrome"} {
+		handler := &savingHandler{}
+		dialer := dialerapi.NewDialer(time.Now(), handler)
+		dialer.ForceSpecificSNI("example.com")
+		if err := dialer.SetTLSFingerprint(fingerprint); err != nil {
+			t.Fatal(err)
+		}
+		if err := dialer.SetECHConfigList(list); err != nil {
+			t.Fatal(err)
+		}
+		ev, err := dialTLSWithHandler(t, dialer, handler, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ev.ConnectionState.ECHAccepted || !bytes.Equal(ev.Config.ECHConfigList, list) {
+			t.Fatal("expected ECH to be accepted and recorded")
+		}
+		if receivedSNI() != "example.com" || ev.Config.SNI != "example.com" {
+			t.Fatal("expected the inner SNI to be example.com")
+		}
+		if ev.ConnectionState.ClientHello.ServerName != "public.example.com" {
+			t.Fatal("expected the outer SNI to be the public name")
+		}
+		handler = &savingHandler{}
+		dialer = dialerapi.NewDialer(time.Now(), handler)
+		dialer.ForceSpecificSNI("example.com")
+		dialer.SetTLSFingerprint(fingerprint)
+		dialer.SetECHConfigList(otherList)
+		ev, err = dialTLSWithHandler(t, dialer, handler, server)
+		if err == nil || ev.Failure != "ssl_ech_rejected" {
+			t.Fatalf("expected ECH to be rejected with %q", fingerprint)
+		}
+		var echRejectionError *tls.ECHRejectionError
+		if !errors.As(err, &echRejectionError) ||
+			!bytes.Equal(echRejectionError.RetryConfigList, list) {
+			t.Fatal("expected the retry configs in the error")
+		}
+	}
+}
+
+func TestUnitSetDomainFronting(t *testing.T) {
+	dialer := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+	if err := dialer.SetDomainFronting("example.org", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if dialer.FrontDomains["example.org"] != "example.com" {
+		t.Fatal("expected the front domain to be set")
+	}
+	if err := dialer.SetDomainFronting("example.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := dialer.FrontDomains["example.org"]; found {
+		t.Fatal("expected the front domain to be removed")
+	}
+}
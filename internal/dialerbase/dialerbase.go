@@ -8,7 +8,9 @@ import (
 	"net"
 	"time"
 
+	"github.com/ooni/netx/internal/connmap"
 	"github.com/ooni/netx/internal/connx"
+	"github.com/ooni/netx/internal/errclass"
 	"github.com/ooni/netx/model"
 )
 
@@ -16,8 +18,10 @@ import (
 // remote TCP/UDP endpoints. DNS is not supported.
 type Dialer struct {
 	net.Dialer
-	Beginning time.Time
-	Handler   model.Handler
+	Beginning       time.Time
+	Handler         model.Handler
+	MaxDataPerConn  int64
+	MaxDataPerEvent int64
 }
 
 // DialHostPort is like net.DialContext but requires a separate host
@@ -37,6 +41,7 @@ func (d *Dialer) DialHostPort(
 			ConnID:        connid,
 			Duration:      stop.Sub(start),
 			Error:         err,
+			Failure:       errclass.Classify(err),
 			LocalAddress:  safeLocalAddress(conn),
 			Network:       network,
 			RemoteAddress: safeRemoteAddress(conn),
@@ -46,11 +51,15 @@ func (d *Dialer) DialHostPort(
 	if err != nil {
 		return nil, err
 	}
+	// Allow HTTP code to map this connection's addresses to its ConnID.
+	connmap.Register(safeLocalAddress(conn), safeRemoteAddress(conn), connid)
 	return &connx.MeasuringConn{
-		Conn:      conn,
-		Beginning: d.Beginning,
-		Handler:   d.Handler,
-		ID:        connid,
+		Conn:            conn,
+		Beginning:       d.Beginning,
+		Handler:         d.Handler,
+		ID:              connid,
+		MaxDataPerConn:  d.MaxDataPerConn,
+		MaxDataPerEvent: d.MaxDataPerEvent,
 	}, nil
 }
 
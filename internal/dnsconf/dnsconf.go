@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"time"
 
 	"github.com/ooni/netx/dnsx"
 	"github.com/ooni/netx/internal/connx"
@@ -13,6 +14,18 @@ import (
 	"github.com/ooni/netx/internal/dnstransport/dnsovertcp"
 	"github.com/ooni/netx/internal/dnstransport/dnsoverudp"
 	"github.com/ooni/netx/internal/godns"
+	"github.com/ooni/netx/internal/oodns"
+)
+
+// These are the DNS engines that can be used on top of a
+// DNS transport. The default is to use the oodns engine.
+const (
+	// EngineGoDNS uses Go's DNS client with a monkey patched
+	// Dial function (see the godns package for details).
+	EngineGoDNS = "godns"
+
+	// EngineOODNS uses OONI's DNS client (see oodns).
+	EngineOODNS = "oodns"
 )
 
 // ConfigureDNS implements netx.Dialer.ConfigureDNS.
@@ -24,11 +37,32 @@ func ConfigureDNS(dialer *dialerapi.Dialer, network, address string) error {
 	return err
 }
 
+// SetDNSEngine implements netx.Dialer.SetDNSEngine.
+func SetDNSEngine(dialer *dialerapi.Dialer, engine string) error {
+	if engine != EngineGoDNS && engine != EngineOODNS {
+		return errors.New("dnsconf: unsupported engine value")
+	}
+	dialer.DNSEngine = engine
+	return nil
+}
+
+// SetDNSDuplicatesWindow implements netx.Dialer.SetDNSDuplicatesWindow.
+func SetDNSDuplicatesWindow(dialer *dialerapi.Dialer, window time.Duration) error {
+	if window < 0 {
+		return errors.New("dnsconf: negative duplicates window")
+	}
+	dialer.DNSDuplicatesWindow = window
+	return nil
+}
+
 // NewResolver returns a new resolver using this Dialer as dialer for
-// creating new network connections used for resolving.
+// creating new network connections used for resolving. The value of
+// dialer.DNSEngine selects the DNS engine to be used with transports
+// (i.e. "udp", "tcp", "dot", and "doh"). The empty string means that
+// we should use the default engine.
 func NewResolver(
 	dialer *dialerapi.Dialer, network, address string,
-) (*net.Resolver, error) {
+) (dnsx.Client, error) {
 	// Implementation note: system and godns need to be dealt with
 	// separately because they don't have any transport.
 	if network == "system" {
@@ -54,13 +88,17 @@ func NewResolver(
 	}
 	var transport dnsx.RoundTripper
 	if network == "doh" {
-		transport = dnsoverhttps.NewTransport(
+		dohTransport := dnsoverhttps.NewTransport(
 			dialer.Beginning, dialer.Handler, address,
 		)
+		dohTransport.Dialer.KeyLogWriter = dialer.KeyLogWriter
+		transport = dohTransport
 	} else if network == "dot" {
-		transport = dnsovertcp.NewTransport(
+		dotTransport := dnsovertcp.NewTransport(
 			dialer.Beginning, dialer.Handler, address,
 		)
+		dotTransport.Dialer.KeyLogWriter = dialer.KeyLogWriter
+		transport = dotTransport
 	} else if network == "tcp" {
 		host, port, err := net.SplitHostPort(address)
 		if err != nil {
@@ -73,12 +111,20 @@ func NewResolver(
 		dotTransport.NoTLS = true
 		transport = dotTransport
 	} else if network == "udp" {
-		transport = dnsoverudp.NewTransport(
+		udpTransport := dnsoverudp.NewTransport(
 			dialer.Beginning, dialer.Handler, address,
 		)
+		udpTransport.DuplicatesWindow = dialer.DNSDuplicatesWindow
+		transport = udpTransport
 	}
 	if transport == nil {
 		return nil, errors.New("dnsconf: unsupported network value")
 	}
-	return godns.NewClient(dialer.Beginning, dialer.Handler, transport), nil
+	if dialer.DNSEngine == EngineGoDNS {
+		return godns.NewClient(dialer.Beginning, dialer.Handler, transport), nil
+	}
+	if dialer.DNSEngine == EngineOODNS || dialer.DNSEngine == "" {
+		return oodns.NewClient(dialer.Beginning, dialer.Handler, transport), nil
+	}
+	return nil, errors.New("dnsconf: unsupported engine value")
 }
@@ -3,13 +3,18 @@ package dnsconf_test
 import (
 	"context"
 	"errors"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/netx/handlers"
 	"github.com/ooni/netx/internal/connx"
 	"github.com/ooni/netx/internal/dialerapi"
 	"github.com/ooni/netx/internal/dnsconf"
+	"github.com/ooni/netx/internal/oodns"
+	"github.com/ooni/netx/model"
 )
 
 func TestIntegrationNewResolver(t *testing.T) {
@@ -153,3 +158,167 @@ func TestIntegrationGoDNSResolverFailure(t *testing.T) {
 		t.Fatal("expected empty addrs here")
 	}
 }
+
+func TestUnitNewResolverEngines(t *testing.T) {
+	d := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+	resolver, err := dnsconf.NewResolver(d, "udp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resolver.(*oodns.Client); !ok {
+		t.Fatal("expected oodns to be the default engine")
+	}
+	err = dnsconf.SetDNSEngine(d, dnsconf.EngineGoDNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver, err = dnsconf.NewResolver(d, "udp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resolver.(*net.Resolver); !ok {
+		t.Fatal("expected the godns engine here")
+	}
+	err = dnsconf.SetDNSEngine(d, dnsconf.EngineOODNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver, err = dnsconf.NewResolver(d, "udp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resolver.(*oodns.Client); !ok {
+		t.Fatal("expected the oodns engine here")
+	}
+}
+
+func TestUnitSetDNSEngineInvalid(t *testing.T) {
+	d := dialerapi.NewDialer(time.Now(), handlers.NoHandler)
+	err := dnsconf.SetDNSEngine(d, "antani")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	d.DNSEngine = "antani" // bypass the check
+	resolver, err := dnsconf.NewResolver(d, "udp", "8.8.8.8:53")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if resolver != nil {
+		t.Fatal("expected a nil resolver here")
+	}
+}
+
+func TestUnitSetDNSDuplicatesWindow(t *testing.T) {
+	// The server replies twice to each query, as it happens when a
+	// censor injects a forged reply and the legitimate one also arrives.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			buffer := make([]byte, 1<<17)
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query := new(dns.Msg)
+			if err := query.Unpack(buffer[:n]); err != nil {
+				return
+			}
+			for _, ip := range []string{"10.10.34.35", "93.184.216.34"} {
+				reply := new(dns.Msg)
+				reply.SetReply(query)
+				// Without RA, Go treats an empty reply as a lame
+				// referral and retries the query.
+				reply.RecursionAvailable = true
+				if query.Question[0].Qtype == dns.TypeA {
+					rr, _ := dns.NewRR("example.com. 3600 IN A " + ip)
+					reply.Answer = append(reply.Answer, rr)
+				}
+				data, _ := reply.Pack()
+				conn.WriteTo(data, addr)
+			}
+		}
+	}()
+	for _, engine := range []string{dnsconf.EngineOODNS, dnsconf.EngineGoDNS} {
+		t.Run(engine, func(t *testing.T) {
+			testDuplicatesWindow(t, engine, conn.LocalAddr().String())
+		})
+	}
+}
+
+func testDuplicatesWindow(t *testing.T, engine, address string) {
+	const window = 250 * time.Millisecond
+	handler := &savingHandler{}
+	d := dialerapi.NewDialer(time.Now(), handler)
+	if err := dnsconf.SetDNSDuplicatesWindow(d, -1); err == nil {
+		t.Fatal("expected an error here")
+	}
+	err := dnsconf.SetDNSDuplicatesWindow(d, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dnsconf.SetDNSEngine(d, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := dnsconf.NewResolver(d, "udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := resolver.LookupHost(context.Background(), "example.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != "10.10.34.35" {
+		t.Fatal("expected the first reply to win")
+	}
+	queries := make(map[int64]*model.DNSQueryEvent)
+	replies := make(map[int64][]*model.DNSReplyEvent)
+	for _, m := range handler.all() {
+		if m.DNSQuery != nil {
+			if queries[m.DNSQuery.ConnID] != nil {
+				t.Fatal("unexpected DNSQueryEvents")
+			}
+			queries[m.DNSQuery.ConnID] = m.DNSQuery
+		}
+		if m.DNSReply != nil {
+			replies[m.DNSReply.ConnID] = append(
+				replies[m.DNSReply.ConnID], m.DNSReply,
+			)
+		}
+	}
+	// We expect the A and the AAAA queries, each with two replies
+	// using the ConnID of the query, and no duplicate events. Since
+	// the server sends both replies at once, each of them must be
+	// reported when it was received rather than after the window.
+	if len(queries) != 2 || len(replies) != 2 {
+		t.Fatal("unexpected number of ConnIDs")
+	}
+	for connid, events := range replies {
+		if queries[connid] == nil || len(events) != 2 {
+			t.Fatal("unexpected DNSReplyEvents")
+		}
+		for _, ev := range events {
+			if ev.Time-queries[connid].Time >= window {
+				t.Fatal("the DNSReplyEvent is late")
+			}
+		}
+	}
+}
+
+type savingHandler struct {
+	measurements []model.Measurement
+	mutex        sync.Mutex
+}
+
+func (h *savingHandler) OnMeasurement(m model.Measurement) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.measurements = append(h.measurements, m)
+}
+
+func (h *savingHandler) all() []model.Measurement {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.measurements
+}
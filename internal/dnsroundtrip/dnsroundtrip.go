@@ -0,0 +1,91 @@
+// Package dnsroundtrip describes DNS round trips. The godns and
+// the oodns engines use this package to emit the same kind of
+// model.DNSRoundTripEvent when a round trip completes.
+package dnsroundtrip
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/netx/dnsx"
+	"github.com/ooni/netx/internal/errclass"
+	"github.com/ooni/netx/model"
+)
+
+// NewEvent creates a new model.DNSRoundTripEvent. The query and the
+// reply are the raw messages sent and received through the transport
+// and err is the error returned by the transport. The start and stop
+// times delimit the round trip, while beginning is the zero time.
+// The transport and server address fields are empty unless the
+// transport is also a dnsx.EndpointRoundTripper.
+func NewEvent(
+	connid int64, transport dnsx.RoundTripper, query, reply []byte,
+	err error, beginning, start, stop time.Time,
+) *model.DNSRoundTripEvent {
+	ev := &model.DNSRoundTripEvent{
+		ConnID:   connid,
+		Duration: stop.Sub(start),
+		Error:    err,
+		Failure:  errclass.Classify(err),
+		Query:    model.DNSMessage{Data: query},
+		Time:     stop.Sub(beginning),
+	}
+	if ert, ok := transport.(dnsx.EndpointRoundTripper); ok {
+		ev.ServerAddress = ert.Address()
+		ev.Transport = ert.Network()
+	}
+	msg := new(dns.Msg)
+	if msg.Unpack(query) == nil {
+		ev.MessageID = msg.Id
+		if len(msg.Question) > 0 {
+			ev.QueryName = msg.Question[0].Name
+			ev.QueryType = dns.TypeToString[msg.Question[0].Qtype]
+		}
+	}
+	if reply == nil {
+		return ev
+	}
+	ev.Reply = model.DNSMessage{Data: reply}
+	msg = new(dns.Msg)
+	if msg.Unpack(reply) == nil {
+		ev.Rcode = dns.RcodeToString[msg.Rcode]
+		for _, answer := range msg.Answer {
+			ev.Answers = append(ev.Answers, answer.String())
+		}
+	}
+	return ev
+}
+
+type connIDKey struct{}
+
+// WithConnID returns a copy of ctx carrying the ConnID assigned by
+// the engine to a DNS round trip. Transports emitting DNS events on
+// their own (e.g. the duplicate replies collected by dnsoverudp)
+// use it so that all the events of a round trip share the ConnID.
+func WithConnID(ctx context.Context, connid int64) context.Context {
+	return context.WithValue(ctx, connIDKey{}, connid)
+}
+
+// ConnID returns the ConnID saved by WithConnID, if any.
+func ConnID(ctx context.Context) (connid int64, found bool) {
+	connid, found = ctx.Value(connIDKey{}).(int64)
+	return
+}
+
+// ReplyEmitter is implemented by transports that may emit on their own
+// a DNSReplyEvent for each reply they receive, using the ConnID saved
+// by WithConnID, so that each event has the time when the reply was
+// actually received (see dnsoverudp.Transport.DuplicatesWindow).
+type ReplyEmitter interface {
+	// EmitsReplyEvents returns true when the transport emits the
+	// DNSReplyEvents, in which case the engine must not.
+	EmitsReplyEvents() bool
+}
+
+// EmitsReplyEvents returns true when transport is a ReplyEmitter
+// that is currently emitting the DNSReplyEvents.
+func EmitsReplyEvents(transport dnsx.RoundTripper) bool {
+	emitter, ok := transport.(ReplyEmitter)
+	return ok && emitter.EmitsReplyEvents()
+}
@@ -0,0 +1,65 @@
+package dnsroundtrip_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/netx/handlers"
+	"github.com/ooni/netx/internal/dnsroundtrip"
+	"github.com/ooni/netx/internal/dnstransport/dnsoverudp"
+)
+
+func TestUnitSuccess(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("ooni.io.", dns.TypeAAAA)
+	querydata, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := new(dns.Msg)
+	reply.SetRcode(query, dns.RcodeNameError)
+	replydata, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	beginning := time.Now()
+	start := beginning.Add(time.Second)
+	stop := start.Add(time.Second)
+	transport := dnsoverudp.NewTransport(
+		beginning, handlers.NoHandler, "9.9.9.9:53",
+	)
+	ev := dnsroundtrip.NewEvent(
+		17, transport, querydata, replydata, nil, beginning, start, stop,
+	)
+	if ev.ConnID != 17 || ev.Duration != time.Second || ev.Time != 2*time.Second {
+		t.Fatal("unexpected ConnID or timing")
+	}
+	if ev.MessageID != query.Id || ev.QueryName != "ooni.io." || ev.QueryType != "AAAA" {
+		t.Fatal("unexpected query fields")
+	}
+	if ev.Rcode != "NXDOMAIN" || len(ev.Answers) != 0 {
+		t.Fatal("unexpected reply fields")
+	}
+	if ev.Transport != "udp" || ev.ServerAddress != "9.9.9.9:53" {
+		t.Fatal("unexpected transport fields")
+	}
+}
+
+func TestUnitFailure(t *testing.T) {
+	transport := dnsoverudp.NewTransport(
+		time.Now(), handlers.NoHandler, "9.9.9.9:53",
+	)
+	now := time.Now()
+	ev := dnsroundtrip.NewEvent(
+		17, transport, []byte{0}, nil, errors.New("mocked error"),
+		now, now, now,
+	)
+	if ev.Error == nil {
+		t.Fatal("expected an error here")
+	}
+	if ev.QueryName != "" || ev.Rcode != "" || ev.Reply.Data != nil {
+		t.Fatal("expected empty fields here")
+	}
+}
@@ -3,6 +3,7 @@ package dnsoverhttps
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -25,6 +26,9 @@ type Transport struct {
 	// initialized in NewTransport to call Client.Do.
 	ClientDo func(req *http.Request) (*http.Response, error)
 
+	// Dialer is the dialer used by Client.
+	Dialer *dialerapi.Dialer
+
 	// URL is the DoH server URL.
 	URL string
 }
@@ -37,22 +41,41 @@ func NewTransport(beginning time.Time, handler model.Handler, URL string) *Trans
 	dialer.TLSConfig = transport.TLSClientConfig
 	transport.Dial = dialer.Dial
 	transport.DialContext = dialer.DialContext
-	transport.DialTLS = dialer.DialTLS
+	transport.DialTLSContext = dialer.DialTLSContext
 	transport.MaxConnsPerHost = 1 // seems to be better for cloudflare DNS
 	client := &http.Client{Transport: transport}
 	return &Transport{
 		Client:   client,
 		ClientDo: client.Do,
+		Dialer:   dialer,
 		URL:      URL,
 	}
 }
 
+// Network returns the transport network.
+func (t *Transport) Network() string {
+	return "doh"
+}
+
+// Address returns the URL of the service.
+func (t *Transport) Address() string {
+	return t.URL
+}
+
 // RoundTrip sends a request and receives a response.
-func (t *Transport) RoundTrip(query []byte) (reply []byte, err error) {
+func (t *Transport) RoundTrip(query []byte) ([]byte, error) {
+	return t.RoundTripContext(context.Background(), query)
+}
+
+// RoundTripContext is like RoundTrip but with a context.
+func (t *Transport) RoundTripContext(
+	ctx context.Context, query []byte,
+) (reply []byte, err error) {
 	req, err := http.NewRequest("POST", t.URL, bytes.NewReader(query))
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("content-type", "application/dns-message")
 	var resp *http.Response
 	resp, err = t.ClientDo(req)
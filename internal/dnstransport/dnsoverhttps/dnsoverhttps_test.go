@@ -1,6 +1,7 @@
 package dnsoverhttps_test
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -112,3 +113,19 @@ func roundTrip(transport *dnsoverhttps.Transport, domain string) error {
 	}
 	return query.Unpack(data)
 }
+
+func TestUnitRoundTripContextCancelled(t *testing.T) {
+	transport := dnsoverhttps.NewTransport(
+		time.Now(), handlers.NoHandler,
+		"https://cloudflare-dns.com/dns-query",
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	reply, err := transport.RoundTripContext(ctx, make([]byte, 128))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
@@ -1,24 +1,31 @@
 // Package dnsovertcp implements DNS over TCP. It is possible to
 // use both plaintext TCP and TLS.
+//
+// We keep a persistent connection with the server and we pipeline
+// queries over it, as described in RFC 7766. Because several queries
+// may be in flight at the same time, we rewrite the ID of each query
+// to make it unique within the connection, and we restore the original
+// ID when we receive the reply. If the connection fails, all the
+// pending queries fail, and we reconnect on the next query. We also
+// close the connection when it has been idle for some time, so that
+// we don't leak connections and goroutines when the Transport is not
+// used anymore and nobody calls Close.
 package dnsovertcp
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"net"
 	"sync"
 	"time"
 
-	"github.com/m-lab/go/rtx"
 	"github.com/ooni/netx/internal/dialerapi"
 	"github.com/ooni/netx/model"
 )
 
 // Transport is a DNS over TCP/TLS dnsx.RoundTripper.
-//
-// As a known bug, this implementation always creates a new connection
-// for each incoming query, thus increasing the response delay.
 type Transport struct {
 	// Dialer is the dialer to use.
 	Dialer *dialerapi.Dialer
@@ -26,6 +33,11 @@ type Transport struct {
 	// Hostname is the hostname of the service.
 	Hostname string
 
+	// IdleTimeout is the time after which we close the persistent
+	// connection if there are no queries in flight. When zero, we
+	// use a default idle timeout of thirty seconds.
+	IdleTimeout time.Duration
+
 	// LookupHost allows you to override the code used to lookup
 	// the address of the DoT server domain name.
 	LookupHost func(host string) (addrs []string, err error)
@@ -36,9 +48,24 @@ type Transport struct {
 	// Port is the port of the service.
 	Port string
 
+	// WriteTimeout is the time after which we consider the persistent
+	// connection broken if we cannot write a query. We don't use the
+	// deadline of the query context, because the connection is shared by
+	// all the pending queries. When zero, we use ten seconds.
+	WriteTimeout time.Duration
+
 	// address is the resolved address of the service.
 	address string
 
+	// conn is the persistent connection, if any.
+	conn *pipelinedConn
+
+	// connMutex protects conn and dialing.
+	connMutex sync.Mutex
+
+	// dialing is closed when the pending dial, if any, completes.
+	dialing chan struct{}
+
 	// init indicates whether we've initialized
 	init bool
 
@@ -96,58 +123,335 @@ func (t *Transport) initialize() (err error) {
 	return
 }
 
+// Network returns the transport network, i.e., "dot" or "tcp".
+func (t *Transport) Network() string {
+	if t.NoTLS == false {
+		return "dot"
+	}
+	return "tcp"
+}
+
+// Address returns the endpoint of the service.
+func (t *Transport) Address() string {
+	t.mutex.Lock() // initialize may be setting the port
+	port := t.Port
+	t.mutex.Unlock()
+	if port == "" && t.NoTLS == false {
+		port = "853"
+	} else if port == "" {
+		port = "53"
+	}
+	return net.JoinHostPort(t.Hostname, port)
+}
+
 // RoundTrip sends a request and receives a response.
 func (t *Transport) RoundTrip(query []byte) ([]byte, error) {
-	var (
-		conn net.Conn
-		err  error
-	)
-	err = t.initialize()
+	return t.RoundTripContext(context.Background(), query)
+}
+
+// RoundTripContext is like RoundTrip but with a context. When the
+// context has no deadline, we give up after ten seconds.
+func (t *Transport) RoundTripContext(
+	ctx context.Context, query []byte,
+) ([]byte, error) {
+	err := t.initialize()
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+	}
+	conn, reused, err := t.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.roundTrip(ctx, query)
+	if err != nil && reused && conn.failed() && ctx.Err() == nil {
+		// The server may have closed the persistent connection while
+		// we were sending the query. Retry once with a new connection.
+		conn, _, err = t.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		reply, err = conn.roundTrip(ctx, query)
+	}
+	return reply, err
+}
+
+// Close closes the persistent connection, if any. You can keep
+// using the Transport, which will reconnect when needed.
+func (t *Transport) Close() error {
+	t.connMutex.Lock()
+	conn := t.conn
+	t.conn = nil
+	t.connMutex.Unlock()
+	if conn != nil {
+		conn.fail(errClosed)
+	}
+	return nil
+}
+
+// getConn returns the persistent connection, creating a new one if
+// needed. The reused return value indicates whether the connection
+// existed already before we were called.
+func (t *Transport) getConn(
+	ctx context.Context,
+) (conn *pipelinedConn, reused bool, err error) {
+	for {
+		t.connMutex.Lock()
+		if t.conn != nil && !t.conn.failed() {
+			conn = t.conn
+			t.connMutex.Unlock()
+			return conn, true, nil
+		}
+		dialing := t.dialing
+		if dialing == nil {
+			t.dialing = make(chan struct{})
+			t.connMutex.Unlock()
+			break
+		}
+		t.connMutex.Unlock()
+		// Another goroutine is dialing. Wait for it to complete and
+		// then check again whether there's a usable connection.
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	// We dial without holding the mutex, so that Close and the other
+	// goroutines waiting for the dial are not blocked by a slow dial.
+	conn, err = t.dial(ctx)
+	t.connMutex.Lock()
+	if err == nil {
+		t.conn = conn
+	}
+	close(t.dialing)
+	t.dialing = nil
+	t.connMutex.Unlock()
+	return conn, false, err
+}
+
+func (t *Transport) dial(ctx context.Context) (*pipelinedConn, error) {
+	var (
+		netconn net.Conn
+		err     error
+	)
 	if t.NoTLS == false {
-		conn, err = t.Dialer.DialTLS(
-			"tcp", net.JoinHostPort(t.address, t.Port),
+		netconn, err = t.Dialer.DialTLSContext(
+			ctx, "tcp", net.JoinHostPort(t.address, t.Port),
 		)
 	} else {
-		conn, err = t.Dialer.Dial(
-			"tcp", net.JoinHostPort(t.address, t.Port),
+		netconn, err = t.Dialer.DialContext(
+			ctx, "tcp", net.JoinHostPort(t.address, t.Port),
 		)
 	}
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-	return t.RoundTripWithConn(conn, query)
+	idleTimeout := t.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 30 * time.Second
+	}
+	writeTimeout := t.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = 10 * time.Second
+	}
+	return newPipelinedConn(netconn, idleTimeout, writeTimeout), nil
+}
+
+var (
+	errClosed        = errors.New("dnsovertcp: connection closed")
+	errIdle          = errors.New("dnsovertcp: idle connection closed")
+	errQueryTooShort = errors.New("dnsovertcp: query too short")
+	errReplyTooShort = errors.New("dnsovertcp: reply too short")
+)
+
+type pipelinedResult struct {
+	err   error
+	reply []byte
+}
+
+// pipelinedConn multiplexes DNS queries over a single connection. A
+// background goroutine reads replies and routes them to the goroutine
+// that is waiting for them, using the DNS message ID. When there are
+// no pending queries for idleTimeout, we close the connection.
+type pipelinedConn struct {
+	conn         net.Conn
+	err          error
+	idleTimeout  time.Duration
+	idleTimer    *time.Timer
+	mutex        sync.Mutex
+	nextID       uint16
+	pending      map[uint16]chan pipelinedResult
+	writeMutex   sync.Mutex
+	writeTimeout time.Duration
+}
+
+func newPipelinedConn(
+	conn net.Conn, idleTimeout, writeTimeout time.Duration,
+) *pipelinedConn {
+	pc := &pipelinedConn{
+		conn:         conn,
+		idleTimeout:  idleTimeout,
+		pending:      make(map[uint16]chan pipelinedResult),
+		writeTimeout: writeTimeout,
+	}
+	pc.mutex.Lock()
+	pc.idleTimer = time.AfterFunc(idleTimeout, pc.closeIfIdle)
+	pc.mutex.Unlock()
+	go pc.readLoop()
+	return pc
+}
+
+// closeIfIdle closes the connection unless there are pending queries.
+func (pc *pipelinedConn) closeIfIdle() {
+	pc.mutex.Lock()
+	if pc.err != nil || len(pc.pending) > 0 {
+		pc.mutex.Unlock()
+		return
+	}
+	pc.err = errIdle
+	pc.mutex.Unlock()
+	pc.conn.Close()
 }
 
-// RoundTripWithConn performs the DNS round trip with a connection.
-func (t *Transport) RoundTripWithConn(conn net.Conn, query []byte) (reply []byte, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			reply = nil // we already got the error just clear the reply
+// removeUnlocked removes a pending query and rearms the idle timer
+// when there are no more pending queries. The caller must hold the
+// mutex.
+func (pc *pipelinedConn) removeUnlocked(id uint16) {
+	delete(pc.pending, id)
+	if len(pc.pending) == 0 && pc.err == nil {
+		pc.idleTimer.Reset(pc.idleTimeout)
+	}
+}
+
+// failed returns true if the connection cannot be used anymore.
+func (pc *pipelinedConn) failed() bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	return pc.err != nil
+}
+
+// fail marks the connection as failed, closes it, and makes all the
+// pending queries fail with the specified error.
+func (pc *pipelinedConn) fail(err error) {
+	pc.mutex.Lock()
+	if pc.err != nil {
+		pc.mutex.Unlock()
+		return
+	}
+	pc.err = err
+	pc.idleTimer.Stop()
+	pending := pc.pending
+	pc.pending = make(map[uint16]chan pipelinedResult)
+	pc.mutex.Unlock()
+	pc.conn.Close()
+	for _, ch := range pending {
+		ch <- pipelinedResult{err: err} // buffered channel
+	}
+}
+
+func (pc *pipelinedConn) readLoop() {
+	reader := bufio.NewReader(pc.conn)
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			pc.fail(err)
+			return
+		}
+		length := int(header[0])<<8 | int(header[1])
+		reply := make([]byte, length)
+		if _, err := io.ReadFull(reader, reply); err != nil {
+			pc.fail(err)
+			return
+		}
+		if length < 2 {
+			pc.fail(errReplyTooShort)
+			return
 		}
-	}()
-	err = conn.SetDeadline(time.Now().Add(10 * time.Second))
-	rtx.PanicOnError(err, "conn.SetDeadline failed")
-	// Write request
-	writer := bufio.NewWriter(conn)
-	err = writer.WriteByte(byte(len(query) >> 8))
-	rtx.PanicOnError(err, "writer.WriteByte failed for first byte")
-	err = writer.WriteByte(byte(len(query)))
-	rtx.PanicOnError(err, "writer.WriteByte failed for second byte")
-	_, err = writer.Write(query)
-	rtx.PanicOnError(err, "writer.Write failed for query")
-	err = writer.Flush()
-	rtx.PanicOnError(err, "writer.Flush failed")
-	// Read response
-	header := make([]byte, 2)
-	_, err = io.ReadFull(conn, header)
-	rtx.PanicOnError(err, "io.ReadFull failed")
-	length := int(header[0])<<8 | int(header[1])
-	reply = make([]byte, length)
-	_, err = io.ReadFull(conn, reply)
-	rtx.PanicOnError(err, "io.ReadFull failed")
-	return reply, nil
+		id := uint16(reply[0])<<8 | uint16(reply[1])
+		pc.mutex.Lock()
+		ch, found := pc.pending[id]
+		if found {
+			pc.removeUnlocked(id)
+		}
+		pc.mutex.Unlock()
+		if found {
+			ch <- pipelinedResult{reply: reply} // buffered channel
+		}
+		// Otherwise, the query has been abandoned (e.g. because its
+		// context expired) and we just ignore the late reply.
+	}
+}
+
+func (pc *pipelinedConn) forget(id uint16) {
+	pc.mutex.Lock()
+	if _, found := pc.pending[id]; found {
+		pc.removeUnlocked(id)
+	}
+	pc.mutex.Unlock()
+}
+
+func (pc *pipelinedConn) roundTrip(
+	ctx context.Context, query []byte,
+) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, errQueryTooShort
+	}
+	ch := make(chan pipelinedResult, 1)
+	pc.mutex.Lock()
+	if pc.err != nil {
+		err := pc.err
+		pc.mutex.Unlock()
+		return nil, err
+	}
+	for {
+		if _, found := pc.pending[pc.nextID]; !found {
+			break
+		}
+		pc.nextID++
+	}
+	id := pc.nextID
+	pc.nextID++
+	pc.pending[id] = ch
+	pc.idleTimer.Stop()
+	pc.mutex.Unlock()
+	frame := make([]byte, 2+len(query))
+	frame[0], frame[1] = byte(len(query)>>8), byte(len(query))
+	copy(frame[2:], query)
+	frame[2], frame[3] = byte(id>>8), byte(id)
+	pc.writeMutex.Lock()
+	if err := ctx.Err(); err != nil {
+		// We have not written anything yet, so only this query fails
+		// and the connection is still good for the other queries.
+		pc.writeMutex.Unlock()
+		pc.forget(id)
+		return nil, err
+	}
+	err := pc.conn.SetWriteDeadline(time.Now().Add(pc.writeTimeout))
+	if err == nil {
+		_, err = pc.conn.Write(frame)
+	}
+	pc.writeMutex.Unlock()
+	if err != nil {
+		// The write deadline is not specific to this query, so the
+		// connection is broken. Also, a partial write breaks the framing
+		// and crypto/tls refuses writing after a failed write.
+		pc.fail(err)
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.reply[0], r.reply[1] = query[0], query[1] // restore original ID
+		return r.reply, nil
+	case <-ctx.Done():
+		pc.forget(id)
+		return nil, ctx.Err()
+	}
 }
@@ -1,9 +1,13 @@
 package dnsovertcp_test
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -83,22 +87,6 @@ func TestIntegrationEmptyLookupReply(t *testing.T) {
 	}
 }
 
-func TestUnitRoundTripWithConnFailure(t *testing.T) {
-	transport := dnsovertcp.NewTransport(
-		time.Now(), handlers.NoHandler, "dns.quad9.net",
-	)
-	query := make([]byte, 1<<10)
-	// fakeconn will fail in the SetDeadline, therefore we will have
-	// an immediate error and we expect all errors the be alike
-	reply, err := transport.RoundTripWithConn(&fakeconn{}, query)
-	if err == nil {
-		t.Fatal("expected an error here")
-	}
-	if reply != nil {
-		t.Fatal("expected nil error here")
-	}
-}
-
 func threeRounds(transport *dnsovertcp.Transport) error {
 	err := roundTrip(transport, "ooni.io.")
 	if err != nil {
@@ -129,31 +117,272 @@ func roundTrip(transport *dnsovertcp.Transport, domain string) error {
 	return query.Unpack(data)
 }
 
-type fakeconn struct{}
+func TestUnitRoundTripContextCancel(t *testing.T) {
+	// The server accepts the connection but never replies, so we
+	// should give up as soon as the context is cancelled.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := dnsovertcp.NewTransport(time.Now(), handlers.NoHandler, host)
+	transport.NoTLS = true
+	transport.Port = port
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	reply, err := transport.RoundTripContext(ctx, make([]byte, 128))
+	if err != context.Canceled {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
 
-func (fakeconn) Read(b []byte) (n int, err error) {
-	n = len(b)
-	return
+func TestUnitPipelining(t *testing.T) {
+	// The server reads two queries and replies in reverse order, which
+	// is only possible if both queries use the same connection.
+	server := newFakeServer(t, func(conn net.Conn) {
+		first, err := readQuery(conn)
+		if err != nil {
+			return
+		}
+		second, err := readQuery(conn)
+		if err != nil {
+			return
+		}
+		writeReply(conn, second)
+		writeReply(conn, first)
+	})
+	defer server.close()
+	transport := server.newTransport()
+	defer transport.Close()
+	errch := make(chan error)
+	for _, domain := range []string{"ooni.io.", "kernel.org."} {
+		go func(domain string) {
+			errch <- roundTripAndCheck(transport, domain)
+		}(domain)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errch; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.numAccepts() != 1 {
+		t.Fatal("expected a single connection")
+	}
 }
-func (fakeconn) Write(b []byte) (n int, err error) {
-	n = len(b)
-	return
+
+func TestUnitExpiredQueryDoesNotBreakConn(t *testing.T) {
+	// The server replies to the first query after some time, so that
+	// it is pending when we send a query whose deadline has expired.
+	server := newFakeServer(t, func(conn net.Conn) {
+		query, err := readQuery(conn)
+		if err != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		writeReply(conn, query)
+		ioutil.ReadAll(conn)
+	})
+	defer server.close()
+	transport := server.newTransport()
+	defer transport.Close()
+	errch := make(chan error)
+	go func() {
+		errch <- roundTripAndCheck(transport, "ooni.io.")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	query := new(dns.Msg)
+	query.SetQuestion("kernel.org.", dns.TypeA)
+	data, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	if _, err := transport.RoundTripContext(ctx, data); err != context.DeadlineExceeded {
+		t.Fatal("expected the expired query to fail")
+	}
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+	if server.numAccepts() != 1 {
+		t.Fatal("expected a single connection")
+	}
 }
-func (fakeconn) Close() (err error) {
-	return
+
+func TestUnitReconnect(t *testing.T) {
+	// The server closes the connection after each reply.
+	server := newFakeServer(t, func(conn net.Conn) {
+		query, err := readQuery(conn)
+		if err != nil {
+			return
+		}
+		writeReply(conn, query)
+	})
+	defer server.close()
+	transport := server.newTransport()
+	defer transport.Close()
+	for _, domain := range []string{"ooni.io.", "kernel.org.", "slashdot.org."} {
+		if err := roundTripAndCheck(transport, domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.numAccepts() < 2 {
+		t.Fatal("expected to reconnect")
+	}
 }
-func (fakeconn) LocalAddr() net.Addr {
-	return &net.TCPAddr{}
+
+func TestUnitIdleTimeout(t *testing.T) {
+	// The server replies to a query and then tells us whether
+	// we have closed the idle connection.
+	closed := make(chan error, 2)
+	server := newFakeServer(t, func(conn net.Conn) {
+		query, err := readQuery(conn)
+		if err != nil {
+			return
+		}
+		writeReply(conn, query)
+		_, err = readQuery(conn)
+		closed <- err
+	})
+	defer server.close()
+	transport := server.newTransport()
+	transport.IdleTimeout = 100 * time.Millisecond
+	defer transport.Close()
+	if err := roundTripAndCheck(transport, "ooni.io."); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-closed:
+		if err != io.EOF {
+			t.Fatal("expected EOF here")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle connection was not closed")
+	}
+	if err := roundTripAndCheck(transport, "kernel.org."); err != nil {
+		t.Fatal(err)
+	}
+	if server.numAccepts() != 2 {
+		t.Fatal("expected to reconnect")
+	}
 }
-func (fakeconn) RemoteAddr() net.Addr {
-	return &net.TCPAddr{}
+
+func TestUnitQueryTooShort(t *testing.T) {
+	server := newFakeServer(t, func(conn net.Conn) {
+		ioutil.ReadAll(conn)
+	})
+	defer server.close()
+	transport := server.newTransport()
+	defer transport.Close()
+	reply, err := transport.RoundTrip([]byte{0})
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
+
+type fakeServer struct {
+	accepts  int64
+	listener net.Listener
+}
+
+func newFakeServer(t *testing.T, handle func(net.Conn)) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&server.accepts, 1)
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return server
+}
+
+func (s *fakeServer) close() {
+	s.listener.Close()
+}
+
+func (s *fakeServer) numAccepts() int64 {
+	return atomic.LoadInt64(&s.accepts)
+}
+
+func (s *fakeServer) newTransport() *dnsovertcp.Transport {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	transport := dnsovertcp.NewTransport(time.Now(), handlers.NoHandler, host)
+	transport.NoTLS = true
+	transport.Port = port
+	return transport
 }
-func (fakeconn) SetDeadline(t time.Time) (err error) {
-	return errors.New("cannot set deadline")
+
+func readQuery(conn net.Conn) (*dns.Msg, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	data := make([]byte, int(header[0])<<8|int(header[1]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	query := new(dns.Msg)
+	err := query.Unpack(data)
+	return query, err
 }
-func (fakeconn) SetReadDeadline(t time.Time) (err error) {
-	return
+
+func writeReply(conn net.Conn, query *dns.Msg) {
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	rr, _ := dns.NewRR(query.Question[0].Name + " 1 IN A 127.0.0.1")
+	reply.Answer = append(reply.Answer, rr)
+	data, _ := reply.Pack()
+	conn.Write(append([]byte{byte(len(data) >> 8), byte(len(data))}, data...))
 }
-func (fakeconn) SetWriteDeadline(t time.Time) (err error) {
-	return
+
+func roundTripAndCheck(transport *dnsovertcp.Transport, domain string) error {
+	query := new(dns.Msg)
+	query.SetQuestion(domain, dns.TypeA)
+	data, err := query.Pack()
+	if err != nil {
+		return err
+	}
+	data, err = transport.RoundTrip(data)
+	if err != nil {
+		return err
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		return err
+	}
+	if reply.Id != query.Id {
+		return errors.New("the reply ID does not match the query ID")
+	}
+	if reply.Question[0].Name != domain {
+		return errors.New("got the reply for another query")
+	}
+	return nil
 }
@@ -1,13 +1,17 @@
 // Package dnsoverudp implements DNS over UDP.
+//
+// On path censors may race the real resolver with forged replies. To
+// detect DNS injection, it is possible to configure the transport to
+// keep reading after the first reply, to collect all the replies.
 package dnsoverudp
 
 import (
 	"context"
-	"net"
 	"time"
 
 	"github.com/ooni/netx/internal/connx"
 	"github.com/ooni/netx/internal/dialerapi"
+	"github.com/ooni/netx/internal/dnsroundtrip"
 	"github.com/ooni/netx/model"
 )
 
@@ -30,8 +34,28 @@ type Transport struct {
 		err error,
 	)
 
-	// Address is the address of the service.
-	Address string
+	// DuplicatesWindow is the amount of time for which we keep
+	// reading after we have received the first reply. The default is
+	// zero, meaning that we stop after the first reply. When this is
+	// positive, we emit a DNSReplyEvent for each received datagram,
+	// including the first one, when we receive it, and RoundTripMulti
+	// returns all of them. In this mode, the DNS engines don't emit the
+	// DNSReplyEvent themselves (see EmitsReplyEvents). Note that, in this
+	// mode, the round trip always takes at least DuplicatesWindow to
+	// complete, and so does the DNSRoundTripEvent Duration.
+	DuplicatesWindow time.Duration
+
+	// address is the address of the service.
+	address string
+}
+
+// Reply is a reply received by RoundTripMulti.
+type Reply struct {
+	// Data contains the reply bytes.
+	Data []byte
+
+	// Time is the time when we received the reply.
+	Time time.Duration
 }
 
 // NewTransport creates a new Transport
@@ -40,33 +64,135 @@ func NewTransport(beginning time.Time, handler model.Handler, address string) *T
 	return &Transport{
 		Dialer:        dialer,
 		DialContextEx: dialer.DialContextEx,
-		Address:       address,
+		address:       address,
 	}
 }
 
+// EmitsReplyEvents implements dnsroundtrip.ReplyEmitter. It returns
+// true when DuplicatesWindow is positive.
+func (t *Transport) EmitsReplyEvents() bool {
+	return t.DuplicatesWindow > 0
+}
+
+// Network returns the transport network.
+func (t *Transport) Network() string {
+	return "udp"
+}
+
+// Address returns the address of the service.
+func (t *Transport) Address() string {
+	return t.address
+}
+
 // RoundTrip sends a request and receives a response.
-func (t *Transport) RoundTrip(query []byte) (reply []byte, err error) {
-	var conn net.Conn
-	conn, _, _, err = t.DialContextEx(
-		context.Background(), "udp", t.Address, true,
-	)
+func (t *Transport) RoundTrip(query []byte) ([]byte, error) {
+	return t.RoundTripContext(context.Background(), query)
+}
+
+// RoundTripContext is like RoundTrip but with a context. When the
+// context has no deadline, we wait for the reply for three seconds.
+func (t *Transport) RoundTripContext(
+	ctx context.Context, query []byte,
+) ([]byte, error) {
+	replies, err := t.RoundTripMulti(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0].Data, nil
+}
+
+// RoundTripMulti is like RoundTripContext except that it returns all
+// the replies received within DuplicatesWindow of the first one. On
+// success, the returned slice contains at least one reply.
+func (t *Transport) RoundTripMulti(
+	ctx context.Context, query []byte,
+) (replies []Reply, err error) {
+	var conn *connx.MeasuringConn
+	conn, _, _, err = t.DialContextEx(ctx, "udp", t.address, true)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
-	err = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	// Use the ConnID of the round trip, if any, for the DNSReplyEvents,
+	// so that they match the events emitted by the engine.
+	connid, found := dnsroundtrip.ConnID(ctx)
+	if !found {
+		connid = conn.ID
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok {
+		deadline = ctxDeadline
+	}
+	err = conn.SetDeadline(deadline)
 	if err != nil {
 		return
 	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock pending I/O. We don't care about the error
+			// because the conn may have already been closed.
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
 	_, err = conn.Write(query)
 	if err != nil {
+		err = contextErrorOr(ctx, err)
 		return
 	}
-	reply = make([]byte, 1<<17)
-	var n int
-	n, err = conn.Read(reply)
-	if err == nil {
-		reply = reply[:n]
+	for {
+		buffer := make([]byte, 1<<17)
+		var n int
+		n, err = conn.Read(buffer)
+		if err != nil && len(replies) > 0 {
+			// The window has expired or the context is done but we
+			// already have at least a reply, so it's a success.
+			return replies, nil
+		}
+		if err != nil {
+			return nil, contextErrorOr(ctx, err)
+		}
+		reply := Reply{
+			Data: buffer[:n],
+			Time: time.Now().Sub(t.Dialer.Beginning),
+		}
+		replies = append(replies, reply)
+		if t.DuplicatesWindow <= 0 {
+			return replies, nil
+		}
+		t.Dialer.Handler.OnMeasurement(model.Measurement{
+			DNSReply: &model.DNSReplyEvent{
+				ConnID: connid,
+				Message: model.DNSMessage{
+					Data: reply.Data,
+				},
+				Time: reply.Time,
+			},
+		})
+		if len(replies) > 1 {
+			continue
+		}
+		windowDeadline := time.Now().Add(t.DuplicatesWindow)
+		if windowDeadline.Before(deadline) {
+			// Ignore the error, because, if the conn is broken,
+			// we'll notice it when reading.
+			conn.SetReadDeadline(windowDeadline)
+		}
+	}
+}
+
+// contextErrorOr returns the context error, if any, or err otherwise. We
+// prefer the context error because it tells us why the I/O failed.
+func contextErrorOr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The I/O deadline may expire slightly before the context does
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
 	}
-	return
+	return err
 }
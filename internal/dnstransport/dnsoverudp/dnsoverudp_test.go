@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/ooni/netx/handlers"
 	"github.com/ooni/netx/internal/connx"
 	"github.com/ooni/netx/internal/dnstransport/dnsoverudp"
+	"github.com/ooni/netx/model"
 )
 
 func TestIntegrationSuccess(t *testing.T) {
@@ -151,3 +153,149 @@ func (c fakeconn) SetReadDeadline(t time.Time) error {
 func (c fakeconn) SetWriteDeadline(t time.Time) error {
 	return c.SetDeadline(t)
 }
+
+func TestUnitRoundTripContextTimeout(t *testing.T) {
+	// The server never replies, so we should give up when the
+	// context deadline expires rather than after three seconds.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	transport := dnsoverudp.NewTransport(
+		time.Now(), handlers.NoHandler, conn.LocalAddr().String(),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	reply, err := transport.RoundTripContext(ctx, make([]byte, 128))
+	if err != context.DeadlineExceeded {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
+
+func TestUnitRoundTripContextCancel(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	transport := dnsoverudp.NewTransport(
+		time.Now(), handlers.NoHandler, conn.LocalAddr().String(),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	reply, err := transport.RoundTripContext(ctx, make([]byte, 128))
+	if err != context.Canceled {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
+
+func TestUnitRoundTripMulti(t *testing.T) {
+	// The server replies twice, as it happens when a censor injects
+	// a forged reply and the legitimate reply also arrives.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buffer := make([]byte, 1<<17)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(buffer[:n], addr)
+		conn.WriteTo(buffer[:n], addr)
+	}()
+	handler := &savingHandler{}
+	transport := dnsoverudp.NewTransport(
+		time.Now(), handler, conn.LocalAddr().String(),
+	)
+	transport.DuplicatesWindow = 500 * time.Millisecond
+	replies, err := transport.RoundTripMulti(
+		context.Background(), []byte("abcdef"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 {
+		t.Fatal("expected two replies")
+	}
+	if replies[1].Time < replies[0].Time {
+		t.Fatal("replies are not ordered by time")
+	}
+	// We expect an event for each reply, including the first one,
+	// with the time when we received the reply.
+	var events []*model.DNSReplyEvent
+	for _, m := range handler.all() {
+		if m.DNSReply != nil {
+			events = append(events, m.DNSReply)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatal("expected two DNSReplyEvents")
+	}
+	for idx, ev := range events {
+		if ev.Time != replies[idx].Time {
+			t.Fatal("unexpected DNSReplyEvent time")
+		}
+	}
+	if !transport.EmitsReplyEvents() {
+		t.Fatal("expected the transport to emit DNSReplyEvents")
+	}
+}
+
+func TestUnitRoundTripMultiNoWindow(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buffer := make([]byte, 1<<17)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(buffer[:n], addr)
+		conn.WriteTo(buffer[:n], addr)
+	}()
+	transport := dnsoverudp.NewTransport(
+		time.Now(), handlers.NoHandler, conn.LocalAddr().String(),
+	)
+	replies, err := transport.RoundTripMulti(
+		context.Background(), []byte("abcdef"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 {
+		t.Fatal("expected a single reply")
+	}
+	if transport.EmitsReplyEvents() {
+		t.Fatal("expected the engine to emit DNSReplyEvents")
+	}
+}
+
+type savingHandler struct {
+	measurements []model.Measurement
+	mutex        sync.Mutex
+}
+
+func (h *savingHandler) OnMeasurement(m model.Measurement) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.measurements = append(h.measurements, m)
+}
+
+func (h *savingHandler) all() []model.Measurement {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.measurements
+}
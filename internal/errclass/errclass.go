@@ -0,0 +1,144 @@
+// Package errclass maps Go errors to stable failure strings. The
+// strings are the ones used by OONI, so that we can aggregate failures
+// across platforms regardless of how each OS phrases an error.
+package errclass
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/ooni/netx/model"
+)
+
+const (
+	// ConnectionRefused indicates ECONNREFUSED.
+	ConnectionRefused = "connection_refused"
+
+	// ConnectionReset indicates ECONNRESET.
+	ConnectionReset = "connection_reset"
+
+	// Discarded indicates that we closed a connection because it
+	// lost the happy eyeballs race (see model.ErrDiscarded).
+	Discarded = "discarded"
+
+	// DNSNXDOMAIN indicates that the domain does not exist.
+	DNSNXDOMAIN = "dns_nxdomain_error"
+
+	// EOF indicates an unexpected EOF.
+	EOF = "eof_error"
+
+	// GenericTimeout indicates any kind of timeout.
+	GenericTimeout = "generic_timeout_error"
+
+	// HostUnreachable indicates EHOSTUNREACH.
+	HostUnreachable = "host_unreachable"
+
+	// Interrupted indicates that the operation has been canceled.
+	Interrupted = "interrupted"
+
+	// NetworkUnreachable indicates ENETUNREACH.
+	NetworkUnreachable = "network_unreachable"
+
+	// SSLECHRejected indicates that the server did not accept
+	// our Encrypted Client Hello.
+	SSLECHRejected = "ssl_ech_rejected"
+
+	// SSLInvalidCertificate indicates an invalid (e.g., expired)
+	// certificate.
+	SSLInvalidCertificate = "ssl_invalid_certificate"
+
+	// SSLInvalidHostname indicates that the certificate is not
+	// valid for the hostname we wanted to connect to.
+	SSLInvalidHostname = "ssl_invalid_hostname"
+
+	// SSLUnknownAuthority indicates that the certificate has been
+	// signed by an authority that we don't trust.
+	SSLUnknownAuthority = "ssl_unknown_authority"
+
+	// UnknownFailurePrefix is the prefix of the failure string we
+	// use when we don't know how to classify an error. The prefix
+	// is followed by the error string.
+	UnknownFailurePrefix = "unknown_failure: "
+)
+
+// Classify returns the failure string corresponding to err. It
+// returns an empty string when err is nil.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	var dialError *model.DialError
+	if errors.As(err, &dialError) {
+		return classifyDialError(dialError)
+	}
+	var hostnameError x509.HostnameError
+	if errors.As(err, &hostnameError) {
+		return SSLInvalidHostname
+	}
+	var unknownAuthorityError x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityError) {
+		return SSLUnknownAuthority
+	}
+	var certificateInvalidError x509.CertificateInvalidError
+	if errors.As(err, &certificateInvalidError) {
+		return SSLInvalidCertificate
+	}
+	var echRejectionError *tls.ECHRejectionError
+	if errors.As(err, &echRejectionError) {
+		return SSLECHRejected
+	}
+	if errors.Is(err, model.ErrDiscarded) {
+		return Discarded
+	}
+	if errors.Is(err, context.Canceled) {
+		return Interrupted
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return EOF
+	}
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) && dnsError.IsNotFound {
+		return DNSNXDOMAIN
+	}
+	s := err.Error()
+	// We also match strings because the errors may have been created
+	// elsewhere (e.g., inside a proxy or by the Go resolver).
+	switch {
+	case strings.HasSuffix(s, "operation was canceled"):
+		return Interrupted
+	case strings.HasSuffix(s, "connection refused"):
+		return ConnectionRefused
+	case strings.HasSuffix(s, "connection reset by peer"):
+		return ConnectionReset
+	case strings.HasSuffix(s, "no route to host"):
+		return HostUnreachable
+	case strings.HasSuffix(s, "network is unreachable"):
+		return NetworkUnreachable
+	case strings.HasSuffix(s, "no such host"):
+		return DNSNXDOMAIN
+	case strings.HasSuffix(s, "i/o timeout"),
+		strings.HasSuffix(s, "TLS handshake timeout"):
+		return GenericTimeout
+	}
+	var timeoutError interface{ Timeout() bool }
+	if errors.As(err, &timeoutError) && timeoutError.Timeout() {
+		return GenericTimeout
+	}
+	return UnknownFailurePrefix + s
+}
+
+// classifyDialError returns the failure of the last attempt, which is
+// the most informative when we have tried all the addresses, or an
+// unknown failure when there are no attempts. Use the attempts to know
+// how each address failed.
+func classifyDialError(err *model.DialError) string {
+	if len(err.Attempts) < 1 {
+		return UnknownFailurePrefix + err.Error()
+	}
+	return Classify(err.Attempts[len(err.Attempts)-1].Error)
+}
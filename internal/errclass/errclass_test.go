@@ -0,0 +1,125 @@
+package errclass_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/ooni/netx/internal/errclass"
+	"github.com/ooni/netx/model"
+)
+
+func TestUnitClassify(t *testing.T) {
+	var cases = []struct {
+		err      error
+		expected string
+	}{{
+		err:      nil,
+		expected: "",
+	}, {
+		err:      context.Canceled,
+		expected: errclass.Interrupted,
+	}, {
+		err:      context.DeadlineExceeded,
+		expected: errclass.GenericTimeout,
+	}, {
+		err:      io.EOF,
+		expected: errclass.EOF,
+	}, {
+		err:      model.ErrDiscarded,
+		expected: errclass.Discarded,
+	}, {
+		err: &net.OpError{
+			Op:  "dial",
+			Net: "tcp",
+			Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+		},
+		expected: errclass.ConnectionRefused,
+	}, {
+		err: &net.OpError{
+			Op:  "read",
+			Net: "tcp",
+			Err: os.NewSyscallError("read", syscall.ECONNRESET),
+		},
+		expected: errclass.ConnectionReset,
+	}, {
+		err: &net.OpError{
+			Op:  "dial",
+			Net: "tcp",
+			Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH),
+		},
+		expected: errclass.HostUnreachable,
+	}, {
+		err: &net.OpError{
+			Op:  "dial",
+			Net: "tcp",
+			Err: os.NewSyscallError("connect", syscall.ENETUNREACH),
+		},
+		expected: errclass.NetworkUnreachable,
+	}, {
+		err: &net.DNSError{
+			Err:        "no such host",
+			Name:       "antani.ooni.io",
+			IsNotFound: true,
+		},
+		expected: errclass.DNSNXDOMAIN,
+	}, {
+		err:      errors.New("lookup antani.ooni.io on 8.8.8.8:53: no such host"),
+		expected: errclass.DNSNXDOMAIN,
+	}, {
+		err:      errors.New("net/http: TLS handshake timeout"),
+		expected: errclass.GenericTimeout,
+	}, {
+		err:      x509.HostnameError{Host: "antani.ooni.io"},
+		expected: errclass.SSLInvalidHostname,
+	}, {
+		err:      x509.UnknownAuthorityError{},
+		expected: errclass.SSLUnknownAuthority,
+	}, {
+		err:      x509.CertificateInvalidError{Reason: x509.Expired},
+		expected: errclass.SSLInvalidCertificate,
+	}, {
+		err:      &tls.ECHRejectionError{},
+		expected: errclass.SSLECHRejected,
+	}, {
+		err:      errors.New("antani"),
+		expected: errclass.UnknownFailurePrefix + "antani",
+	}}
+	for _, c := range cases {
+		if failure := errclass.Classify(c.err); failure != c.expected {
+			t.Fatalf("%+v: expected %s, got %s", c.err, c.expected, failure)
+		}
+	}
+}
+
+func TestUnitClassifyDialError(t *testing.T) {
+	refused := os.NewSyscallError("connect", syscall.ECONNREFUSED)
+	err := &model.DialError{
+		Attempts: []model.DialAttempt{{
+			Address: "127.0.0.1:443",
+			Error:   refused,
+		}, {
+			Address: "[::1]:443",
+			Error:   refused,
+		}},
+		Hostname: "antani.local",
+		Network:  "tcp",
+	}
+	if failure := errclass.Classify(err); failure != errclass.ConnectionRefused {
+		t.Fatal("expected the failure shared by all attempts")
+	}
+	err.Attempts[1].Error = context.DeadlineExceeded
+	if failure := errclass.Classify(err); failure != errclass.GenericTimeout {
+		t.Fatal("expected the failure of the last attempt")
+	}
+	err.Attempts = nil
+	if failure := errclass.Classify(err); failure != errclass.UnknownFailurePrefix+err.Error() {
+		t.Fatal("expected an unknown failure")
+	}
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/ooni/netx/dnsx"
 	"github.com/ooni/netx/internal/connx"
 	"github.com/ooni/netx/internal/dialerapi"
+	"github.com/ooni/netx/internal/dnsroundtrip"
 	"github.com/ooni/netx/model"
 )
 
@@ -48,7 +49,7 @@ func NewClient(
 	return &net.Resolver{
 		PreferGo: true,
 		Dial: func(c context.Context, n string, a string) (net.Conn, error) {
-			return NewPseudoConn(beginning, handler, transport), nil
+			return NewPseudoConnWithContext(c, beginning, handler, transport), nil
 		},
 	}
 }
@@ -59,12 +60,16 @@ type godnsResult struct {
 }
 
 type pseudoConn struct {
-	ch    chan godnsResult
-	id    int64
-	mutex sync.Mutex
-	rd    time.Time
-	t     dnsx.RoundTripper
-	wd    time.Time
+	beginning time.Time
+	cancel    context.CancelFunc
+	ch        chan godnsResult
+	ctx       context.Context
+	handler   model.Handler
+	id        int64
+	mutex     sync.Mutex
+	rd        time.Time
+	t         dnsx.RoundTripper
+	wd        time.Time
 }
 
 // NewPseudoConn creates a new pseudo connection attached to the
@@ -72,19 +77,37 @@ type pseudoConn struct {
 // to the conn to send it, and to read to receive the reply.
 func NewPseudoConn(
 	beginning time.Time, handler model.Handler, transport dnsx.RoundTripper,
+) net.Conn {
+	return NewPseudoConnWithContext(
+		context.Background(), beginning, handler, transport,
+	)
+}
+
+// NewPseudoConnWithContext is like NewPseudoConn but the context
+// allows to interrupt pending round trips. Closing the pseudo
+// connection also interrupts pending round trips.
+func NewPseudoConnWithContext(
+	ctx context.Context, beginning time.Time, handler model.Handler,
+	transport dnsx.RoundTripper,
 ) net.Conn {
 	connid := dialerapi.NextConnID()
+	ctx, cancel := context.WithCancel(ctx)
 	conn := net.Conn(&connx.DNSMeasuringConn{
 		MeasuringConn: connx.MeasuringConn{
 			Conn: &pseudoConn{
-				ch: make(chan godnsResult),
-				id: connid,
-				t:  transport,
+				beginning: beginning,
+				cancel:    cancel,
+				ch:        make(chan godnsResult),
+				ctx:       ctx,
+				handler:   handler,
+				id:        connid,
+				t:         transport,
 			},
 			Beginning: beginning,
 			Handler:   handler,
 			ID:        connid,
 		},
+		NoReplyEvents: dnsroundtrip.EmitsReplyEvents(transport),
 	})
 	handler.OnMeasurement(model.Measurement{
 		Connect: &model.ConnectEvent{
@@ -101,6 +124,7 @@ func NewPseudoConn(
 }
 
 func (c *pseudoConn) Close() (err error) {
+	c.cancel()
 	return
 }
 
@@ -174,24 +198,45 @@ func (c *pseudoConn) Write(b []byte) (n int, err error) {
 	// An implementation may be tempted to assume that Write on a newly
 	// created UDP socket always succeeds. While this is probably not the
 	// case for golang, being defensive never hurts too much.
-	go c.lookup(b)
+	ctx, cancel := c.ctx, context.CancelFunc(func() {})
+	c.mutex.Lock()
+	wd := c.wd
+	c.mutex.Unlock()
+	if !wd.IsZero() {
+		// The Go resolver uses the same deadline for the whole
+		// exchange, so the write deadline bounds the round trip.
+		ctx, cancel = context.WithDeadline(ctx, wd)
+	}
+	go c.lookup(ctx, cancel, b)
 	return len(b), nil
 }
 
-func (c *pseudoConn) lookup(b []byte) {
+func (c *pseudoConn) lookup(
+	ctx context.Context, cancel context.CancelFunc, b []byte,
+) {
+	defer cancel()
 	// If no-one shows up for reading what we have for them for some time
 	// then simply give up sending to the channel.
 	timer := time.NewTimer(3 * time.Second)
 	defer timer.Stop()
 	select {
-	case c.ch <- c.do(b):
+	case c.ch <- c.do(ctx, b):
 		// NOTHING
 	case <-timer.C:
 		// NOTHING
 	}
 }
 
-func (c *pseudoConn) do(query []byte) (r godnsResult) {
-	r.reply, r.err = c.t.RoundTrip(query)
+func (c *pseudoConn) do(ctx context.Context, query []byte) (r godnsResult) {
+	start := time.Now()
+	r.reply, r.err = dnsx.RoundTripContext(
+		dnsroundtrip.WithConnID(ctx, c.id), c.t, query,
+	)
+	stop := time.Now()
+	c.handler.OnMeasurement(model.Measurement{
+		DNSRoundTrip: dnsroundtrip.NewEvent(
+			c.id, c.t, query, r.reply, r.err, c.beginning, start, stop,
+		),
+	})
 	return r
 }
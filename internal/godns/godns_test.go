@@ -46,3 +46,42 @@ func TestIntegrationReadWithTimeout(t *testing.T) {
 		t.Fatal("expected to see zero bytes here")
 	}
 }
+
+func TestUnitLookupHostContextTimeout(t *testing.T) {
+	start := time.Now()
+	client := godns.NewClient(start, handlers.NoHandler, blockingTransport{})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	addrs, err := client.LookupHost(ctx, "ooni.io")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(addrs) != 0 {
+		t.Fatal("expected no addresses here")
+	}
+	if time.Now().Sub(start) > time.Second {
+		t.Fatal("the context did not interrupt the round trip")
+	}
+}
+
+// blockingTransport blocks until the context is done.
+type blockingTransport struct{}
+
+func (bt blockingTransport) RoundTrip(query []byte) ([]byte, error) {
+	return bt.RoundTripContext(context.Background(), query)
+}
+
+func (blockingTransport) RoundTripContext(
+	ctx context.Context, query []byte,
+) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingTransport) Network() string {
+	return "blocking"
+}
+
+func (blockingTransport) Address() string {
+	return ""
+}
@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/ooni/netx/internal/connmap"
 	"github.com/ooni/netx/model"
 	"golang.org/x/net/http2"
 )
@@ -22,6 +23,11 @@ type Transport struct {
 	http.Transport
 	Handler   model.Handler
 	Beginning time.Time
+
+	// FrontDomains maps the hosts we reach using domain fronting to
+	// the corresponding front domain. We only use it to record the front
+	// domain, since domain fronting is implemented by the dialer.
+	FrontDomains map[string]string
 }
 
 // NewTransport creates a new Transport.
@@ -51,16 +57,44 @@ func NewTransport(beginning time.Time, handler model.Handler) *Transport {
 func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	outmethod := req.Method
 	outurl := req.URL.String()
+	var frontDomain string
+	if req.URL.Scheme == "https" {
+		frontDomain = t.FrontDomains[req.URL.Hostname()]
+	}
 	tid := atomic.AddInt64(&nextTransactionID, 1)
 	outheaders := http.Header{}
-	var mutex sync.Mutex
+	var (
+		connid int64
+		mutex  sync.Mutex
+	)
+	getConnID := func() int64 {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return connid
+	}
 	tracer := &httptrace.ClientTrace{
 		GotConn: func(info httptrace.GotConnInfo) {
+			localAddr := info.Conn.LocalAddr().String()
+			remoteAddr := info.Conn.RemoteAddr().String()
+			// The ConnID is zero if the conn was not created by our dialer
+			id, _ := connmap.Lookup(localAddr, remoteAddr)
+			mutex.Lock()
+			connid = id
+			mutex.Unlock()
 			t.Handler.OnMeasurement(model.Measurement{
 				HTTPConnectionReady: &model.HTTPConnectionReadyEvent{
-					LocalAddress:  info.Conn.LocalAddr().String(),
+					ConnID:        id,
+					LocalAddress:  localAddr,
 					Network:       info.Conn.LocalAddr().Network(),
-					RemoteAddress: info.Conn.RemoteAddr().String(),
+					RemoteAddress: remoteAddr,
+					Time:          time.Now().Sub(t.Beginning),
+					TransactionID: tid,
+				},
+			})
+			// We start sending the request as soon as we have a conn
+			t.Handler.OnMeasurement(model.Measurement{
+				HTTPRequestStart: &model.HTTPRequestStartEvent{
+					ConnID:        id,
 					Time:          time.Now().Sub(t.Beginning),
 					TransactionID: tid,
 				},
@@ -75,6 +109,8 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 			mutex.Lock()
 			m := model.Measurement{
 				HTTPRequestHeadersDone: &model.HTTPRequestHeadersDoneEvent{
+					ConnID:        connid,
+					FrontDomain:   frontDomain,
 					Headers:       outheaders,
 					Method:        outmethod,
 					Time:          time.Now().Sub(t.Beginning),
@@ -88,6 +124,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		WroteRequest: func(info httptrace.WroteRequestInfo) {
 			t.Handler.OnMeasurement(model.Measurement{
 				HTTPRequestDone: &model.HTTPRequestDoneEvent{
+					ConnID:        getConnID(),
 					Time:          time.Now().Sub(t.Beginning),
 					TransactionID: tid,
 				},
@@ -96,6 +133,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		GotFirstResponseByte: func() {
 			t.Handler.OnMeasurement(model.Measurement{
 				HTTPResponseStart: &model.HTTPResponseStartEvent{
+					ConnID:        getConnID(),
 					Time:          time.Now().Sub(t.Beginning),
 					TransactionID: tid,
 				},
@@ -109,6 +147,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 	}
 	t.Handler.OnMeasurement(model.Measurement{
 		HTTPResponseHeadersDone: &model.HTTPResponseHeadersDoneEvent{
+			ConnID:        getConnID(),
 			Headers:       resp.Header,
 			StatusCode:    int64(resp.StatusCode),
 			Time:          time.Now().Sub(t.Beginning),
@@ -120,6 +159,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 	//  a zero-length body." (from the docs)
 	resp.Body = &bodyWrapper{
 		ReadCloser: resp.Body,
+		connid:     getConnID(),
 		t:          t,
 		tid:        tid,
 	}
@@ -128,14 +168,16 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 
 type bodyWrapper struct {
 	io.ReadCloser
-	t   *Transport
-	tid int64
+	connid int64
+	t      *Transport
+	tid    int64
 }
 
 func (bw *bodyWrapper) Close() (err error) {
 	err = bw.ReadCloser.Close()
 	bw.t.Handler.OnMeasurement(model.Measurement{
 		HTTPResponseDone: &model.HTTPResponseDoneEvent{
+			ConnID:        bw.connid,
 			Time:          time.Now().Sub(bw.t.Beginning),
 			TransactionID: bw.tid,
 		},
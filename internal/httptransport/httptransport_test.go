@@ -3,11 +3,16 @@ package httptransport_test
 import (
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ooni/netx/handlers"
+	"github.com/ooni/netx/internal/dialerapi"
 	"github.com/ooni/netx/internal/httptransport"
+	"github.com/ooni/netx/model"
 )
 
 func TestIntegration(t *testing.T) {
@@ -39,3 +44,86 @@ func TestIntegrationFailure(t *testing.T) {
 		t.Fatal("expected a nil response here")
 	}
 }
+
+func TestIntegrationConnID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		},
+	))
+	defer server.Close()
+	handler := &savingHandler{}
+	beginning := time.Now()
+	dialer := dialerapi.NewDialer(beginning, handler)
+	transport := httptransport.NewTransport(beginning, handler)
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var connectID int64
+	seen := make(map[string]int64)
+	for _, m := range handler.all() {
+		if m.Connect != nil {
+			connectID = m.Connect.ConnID
+		}
+		for name, id := range map[string]*int64{
+			"HTTPConnectionReady":     connIDOrNil(m.HTTPConnectionReady),
+			"HTTPRequestStart":        connIDOrNil(m.HTTPRequestStart),
+			"HTTPRequestHeadersDone":  connIDOrNil(m.HTTPRequestHeadersDone),
+			"HTTPRequestDone":         connIDOrNil(m.HTTPRequestDone),
+			"HTTPResponseStart":       connIDOrNil(m.HTTPResponseStart),
+			"HTTPResponseHeadersDone": connIDOrNil(m.HTTPResponseHeadersDone),
+			"HTTPResponseDone":        connIDOrNil(m.HTTPResponseDone),
+		} {
+			if id == nil {
+				continue
+			}
+			if *id != connectID || connectID == 0 {
+				t.Fatalf("%s has the wrong ConnID", name)
+			}
+			seen[name]++
+		}
+	}
+	if len(seen) != 7 {
+		t.Fatalf("did not see all the HTTP events: %+v", seen)
+	}
+	for name, count := range seen {
+		if count != 1 {
+			t.Fatalf("expected a single %s event", name)
+		}
+	}
+}
+
+// connIDOrNil returns a pointer to the ConnID field of the HTTP
+// event, or nil if the event is nil.
+func connIDOrNil(event interface{}) *int64 {
+	value := reflect.ValueOf(event)
+	if value.IsNil() {
+		return nil
+	}
+	return value.Elem().FieldByName("ConnID").Addr().Interface().(*int64)
+}
+
+type savingHandler struct {
+	measurements []model.Measurement
+	mutex        sync.Mutex
+}
+
+func (h *savingHandler) OnMeasurement(m model.Measurement) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.measurements = append(h.measurements, m)
+}
+
+func (h *savingHandler) all() []model.Measurement {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.measurements
+}
@@ -1,20 +1,25 @@
 // Package oodns is OONI's DNS client.
 //
-// This is currently experimental code that is not wired into the
-// rest of the code. We want to understand if we can always use the
-// github.com/miekg/dns client to implement dnsx.Client.
-//
-// If that is possible, then maybe we can fully replace the current
-// situation in which we monkey patch Go's +netgo DNS client.
+// It uses the github.com/miekg/dns library to implement dnsx.Client,
+// plus a generic Lookup method that allows to query for record types
+// that the Go resolver cannot ask for. This is the default engine used
+// when configuring a DNS transport. The godns engine, where we monkey
+// patch Go's +netgo DNS client, is still available.
 package oodns
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/ooni/netx/dnsx"
+	"github.com/ooni/netx/internal/dialerapi"
+	"github.com/ooni/netx/internal/dnsroundtrip"
 	"github.com/ooni/netx/model"
 )
 
@@ -22,36 +27,104 @@ import (
 // manually create and submit queries. It can use all the transports
 // for DNS supported by this library, however.
 type Client struct {
+	beginning time.Time
 	handler   model.Handler
 	transport dnsx.RoundTripper
 }
 
 // NewClient creates a new OONI DNS client instance.
-func NewClient(handler model.Handler, t dnsx.RoundTripper) *Client {
+func NewClient(
+	beginning time.Time, handler model.Handler, t dnsx.RoundTripper,
+) *Client {
 	return &Client{
+		beginning: beginning,
 		handler:   handler,
 		transport: t,
 	}
 }
 
-var errNotImpl = errors.New("Not implemented")
+// TypeSVCB and TypeHTTPS are the SVCB and HTTPS query types. The
+// version of github.com/miekg/dns we use does not define them, hence
+// the records returned by Lookup for these types are *dns.RFC3597,
+// which you can decode using ParseSVCB.
+const (
+	TypeSVCB  uint16 = 64
+	TypeHTTPS uint16 = 65
+)
+
+var errNoAnswer = errors.New("oodns: no answer")
+
+// Lookup sends a query for the given name and query type and returns
+// all the resource records contained in the answer section.
+func (c *Client) Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	reply, err := c.roundTrip(ctx, c.newQueryWithQuestion(dns.Question{
+		Name:   dns.Fqdn(name),
+		Qtype:  qtype,
+		Qclass: dns.ClassINET,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return reply.Answer, nil
+}
 
 // LookupAddr returns the name of the provided IP address
 func (c *Client) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
-	err = errNotImpl
+	var reverse string
+	reverse, err = dns.ReverseAddr(addr)
+	if err != nil {
+		return
+	}
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, reverse, dns.TypePTR)
+	if err != nil {
+		return
+	}
+	for _, answer := range answers {
+		if rr, ok := answer.(*dns.PTR); ok {
+			names = append(names, rr.Ptr)
+		}
+	}
+	if len(names) < 1 {
+		err = errNoAnswer
+	}
 	return
 }
 
 // LookupCNAME returns the canonical name of a host
 func (c *Client) LookupCNAME(ctx context.Context, host string) (cname string, err error) {
-	err = errNotImpl
-	return
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, host, dns.TypeA)
+	if err != nil {
+		return
+	}
+	return FollowCNAMEChain(dns.Fqdn(host), answers), nil
+}
+
+// FollowCNAMEChain follows the chain of CNAME records in answers starting
+// from name and returns the canonical name. If there are no CNAME records
+// for name, the canonical name is name itself. You generally only care
+// about this function when writing tests.
+func FollowCNAMEChain(name string, answers []dns.RR) string {
+	// Bound the number of iterations to protect against loops
+	for i := 0; i < len(answers); i++ {
+		found := false
+		for _, answer := range answers {
+			rr, ok := answer.(*dns.CNAME)
+			if ok && strings.EqualFold(rr.Hdr.Name, name) {
+				name, found = rr.Target, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return name
 }
 
 // LookupHost returns the IP addresses of a host
 func (c *Client) LookupHost(ctx context.Context, hostname string) ([]string, error) {
-	// TODO(ooni): wrap errors as net.DNSError
-	// TODO(ooni): emit DNS messages
 	var addrs []string
 	var reply *dns.Msg
 	reply, errA := c.roundTrip(ctx, c.newQueryWithQuestion(dns.Question{
@@ -100,13 +173,198 @@ func LookupHostResult(addrs []string, errA, errAAAA error) ([]string, error) {
 
 // LookupMX returns the MX records of a specific name
 func (c *Client) LookupMX(ctx context.Context, name string) (mx []*net.MX, err error) {
-	err = errNotImpl
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, name, dns.TypeMX)
+	if err != nil {
+		return
+	}
+	for _, answer := range answers {
+		if rr, ok := answer.(*dns.MX); ok {
+			mx = append(mx, &net.MX{Host: rr.Mx, Pref: rr.Preference})
+		}
+	}
+	if len(mx) < 1 {
+		err = errNoAnswer
+	}
 	return
 }
 
 // LookupNS returns the NS records of a specific name
 func (c *Client) LookupNS(ctx context.Context, name string) (ns []*net.NS, err error) {
-	err = errNotImpl
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, name, dns.TypeNS)
+	if err != nil {
+		return
+	}
+	for _, answer := range answers {
+		if rr, ok := answer.(*dns.NS); ok {
+			ns = append(ns, &net.NS{Host: rr.Ns})
+		}
+	}
+	if len(ns) < 1 {
+		err = errNoAnswer
+	}
+	return
+}
+
+// LookupTXT returns the TXT records of a specific name. Like it happens
+// with net.Resolver, the strings of each record are joined together.
+func (c *Client) LookupTXT(ctx context.Context, name string) (txt []string, err error) {
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return
+	}
+	for _, answer := range answers {
+		if rr, ok := answer.(*dns.TXT); ok {
+			txt = append(txt, strings.Join(rr.Txt, ""))
+		}
+	}
+	if len(txt) < 1 {
+		err = errNoAnswer
+	}
+	return
+}
+
+// LookupSRV returns the SRV records of a specific service. The arguments
+// have the same meaning they have in net.Resolver.LookupSRV.
+func (c *Client) LookupSRV(
+	ctx context.Context, service, proto, name string,
+) (cname string, srv []*net.SRV, err error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, target, dns.TypeSRV)
+	if err != nil {
+		return
+	}
+	cname = FollowCNAMEChain(dns.Fqdn(target), answers)
+	for _, answer := range answers {
+		if rr, ok := answer.(*dns.SRV); ok {
+			srv = append(srv, &net.SRV{
+				Target:   rr.Target,
+				Port:     rr.Port,
+				Priority: rr.Priority,
+				Weight:   rr.Weight,
+			})
+		}
+	}
+	if len(srv) < 1 {
+		err = errNoAnswer
+	}
+	return
+}
+
+// SVCB is a decoded SVCB or HTTPS record (RFC 9460). Params contains
+// all the SvcParams by key, while the other fields contain the decoded
+// value of the most commonly used SvcParams, if present.
+type SVCB struct {
+	ALPN     []string
+	ECH      []byte
+	IPHints  []net.IP
+	Params   map[uint16][]byte
+	Port     uint16
+	Priority uint16
+	Target   string
+}
+
+var errInvalidSVCB = errors.New("oodns: invalid SVCB record")
+
+// ParseSVCB decodes a SVCB or HTTPS record returned by Lookup.
+func ParseSVCB(rr dns.RR) (*SVCB, error) {
+	unknown, ok := rr.(*dns.RFC3597)
+	if !ok || (rr.Header().Rrtype != TypeSVCB && rr.Header().Rrtype != TypeHTTPS) {
+		return nil, errInvalidSVCB
+	}
+	rdata, err := hex.DecodeString(unknown.Rdata)
+	if err != nil || len(rdata) < 2 {
+		return nil, errInvalidSVCB
+	}
+	svcb := &SVCB{
+		Params:   make(map[uint16][]byte),
+		Priority: binary.BigEndian.Uint16(rdata),
+	}
+	target, off, err := dns.UnpackDomainName(rdata, 2)
+	if err != nil {
+		return nil, errInvalidSVCB
+	}
+	svcb.Target = target
+	for off < len(rdata) {
+		if len(rdata)-off < 4 {
+			return nil, errInvalidSVCB
+		}
+		key := binary.BigEndian.Uint16(rdata[off:])
+		length := int(binary.BigEndian.Uint16(rdata[off+2:]))
+		off += 4
+		if len(rdata)-off < length {
+			return nil, errInvalidSVCB
+		}
+		value := rdata[off : off+length]
+		off += length
+		svcb.Params[key] = value
+		if err := svcb.decodeParam(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return svcb, nil
+}
+
+func (svcb *SVCB) decodeParam(key uint16, value []byte) error {
+	switch key {
+	case 1: // alpn
+		for len(value) > 0 {
+			length := int(value[0])
+			if len(value) < 1+length {
+				return errInvalidSVCB
+			}
+			svcb.ALPN = append(svcb.ALPN, string(value[1:1+length]))
+			value = value[1+length:]
+		}
+	case 3: // port
+		if len(value) != 2 {
+			return errInvalidSVCB
+		}
+		svcb.Port = binary.BigEndian.Uint16(value)
+	case 4, 6: // ipv4hint, ipv6hint
+		size := net.IPv4len
+		if key == 6 {
+			size = net.IPv6len
+		}
+		if len(value)%size != 0 {
+			return errInvalidSVCB
+		}
+		for ; len(value) > 0; value = value[size:] {
+			svcb.IPHints = append(svcb.IPHints, net.IP(value[:size]))
+		}
+	case 5: // ech
+		svcb.ECH = value
+	}
+	return nil
+}
+
+// LookupHTTPS returns the decoded HTTPS records of a specific name.
+func (c *Client) LookupHTTPS(ctx context.Context, name string) (https []*SVCB, err error) {
+	var answers []dns.RR
+	answers, err = c.Lookup(ctx, name, TypeHTTPS)
+	if err != nil {
+		return
+	}
+	for _, answer := range answers {
+		if answer.Header().Rrtype != TypeHTTPS {
+			continue
+		}
+		var svcb *SVCB
+		svcb, err = ParseSVCB(answer)
+		if err != nil {
+			return nil, err
+		}
+		https = append(https, svcb)
+	}
+	if len(https) < 1 {
+		err = errNoAnswer
+	}
 	return
 }
 
@@ -124,8 +382,10 @@ func (c *Client) roundTrip(ctx context.Context, query *dns.Msg) (reply *dns.Msg,
 		ctx, query, func(msg *dns.Msg) ([]byte, error) {
 			return msg.Pack()
 		},
-		func(t dnsx.RoundTripper, query []byte) (reply []byte, err error) {
-			return t.RoundTrip(query)
+		func(
+			ctx context.Context, t dnsx.RoundTripper, query []byte,
+		) (reply []byte, err error) {
+			return dnsx.RoundTripContext(ctx, t, query)
 		},
 		func(msg *dns.Msg, data []byte) (err error) {
 			return msg.Unpack(data)
@@ -134,15 +394,20 @@ func (c *Client) roundTrip(ctx context.Context, query *dns.Msg) (reply *dns.Msg,
 }
 
 // RoundTripEx is a mockable implementation of the piece
-// of code that performs the DNS round trip.
+// of code that performs the DNS round trip. Like the godns engine,
+// we emit a DNSQueryEvent, a DNSReplyEvent, and a DNSRoundTripEvent.
+// Each round trip is assigned a new ConnID, as if we were using a new
+// pseudo connection for each query, which is what godns does. When the
+// transport emits the DNSReplyEvents itself, we don't.
 func (c *Client) RoundTripEx(
 	ctx context.Context,
 	query *dns.Msg,
 	pack func(msg *dns.Msg) ([]byte, error),
-	roundTrip func(t dnsx.RoundTripper, query []byte) (reply []byte, err error),
+	roundTrip func(
+		ctx context.Context, t dnsx.RoundTripper, query []byte,
+	) (reply []byte, err error),
 	unpack func(msg *dns.Msg, data []byte) (err error),
 ) (reply *dns.Msg, err error) {
-	// TODO(ooni): we are ignoring the context here
 	var (
 		querydata []byte
 		replydata []byte
@@ -151,15 +416,56 @@ func (c *Client) RoundTripEx(
 	if err != nil {
 		return
 	}
-	replydata, err = roundTrip(c.transport, querydata)
+	connid := dialerapi.NextConnID()
+	c.handler.OnMeasurement(model.Measurement{
+		DNSQuery: &model.DNSQueryEvent{
+			ConnID: connid,
+			Message: model.DNSMessage{
+				Data: querydata,
+			},
+			Time: time.Now().Sub(c.beginning),
+		},
+	})
+	start := time.Now()
+	replydata, err = roundTrip(
+		dnsroundtrip.WithConnID(ctx, connid), c.transport, querydata,
+	)
+	stop := time.Now()
+	c.handler.OnMeasurement(model.Measurement{
+		DNSRoundTrip: dnsroundtrip.NewEvent(
+			connid, c.transport, querydata, replydata, err,
+			c.beginning, start, stop,
+		),
+	})
 	if err != nil {
 		return
 	}
+	if !dnsroundtrip.EmitsReplyEvents(c.transport) {
+		c.handler.OnMeasurement(model.Measurement{
+			DNSReply: &model.DNSReplyEvent{
+				ConnID: connid,
+				Message: model.DNSMessage{
+					Data: replydata,
+				},
+				Time: time.Now().Sub(c.beginning),
+			},
+		})
+	}
 	reply = new(dns.Msg)
 	err = unpack(reply, replydata)
 	if err != nil {
 		return
 	}
+	if reply.Rcode == dns.RcodeNameError && len(query.Question) > 0 {
+		// Behave like the Go resolver, so code checking for a
+		// nonexistent domain works with both engines.
+		err = &net.DNSError{
+			Err:        "no such host",
+			Name:       strings.TrimSuffix(query.Question[0].Name, "."),
+			IsNotFound: true,
+		}
+		return
+	}
 	if reply.Rcode != dns.RcodeSuccess {
 		err = errors.New("oodns: query failed")
 		return
@@ -1,8 +1,10 @@
 package oodns_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -11,17 +13,18 @@ import (
 	"github.com/ooni/netx/handlers"
 	"github.com/ooni/netx/internal/dnstransport/dnsovertcp"
 	"github.com/ooni/netx/internal/oodns"
+	"github.com/ooni/netx/model"
 )
 
 func TestLookupAddr(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
 	addrs, err := client.LookupAddr(context.Background(), "130.192.91.211")
-	if err == nil {
-		t.Fatal("expected an error here")
+	if err != nil {
+		t.Fatal(err)
 	}
 	for _, addr := range addrs {
 		t.Log(addr)
@@ -30,22 +33,20 @@ func TestLookupAddr(t *testing.T) {
 
 func TestLookupCNAME(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
-	addrs, err := client.LookupCNAME(context.Background(), "www.ooni.io")
-	if err == nil {
-		t.Fatal("expected an error here")
-	}
-	for _, addr := range addrs {
-		t.Log(addr)
+	cname, err := client.LookupCNAME(context.Background(), "www.ooni.io")
+	if err != nil {
+		t.Fatal(err)
 	}
+	t.Log(cname)
 }
 
 func TestLookupHost(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
@@ -60,7 +61,7 @@ func TestLookupHost(t *testing.T) {
 
 func TestLookupNonexistent(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
@@ -75,13 +76,13 @@ func TestLookupNonexistent(t *testing.T) {
 
 func TestLookupMX(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
 	addrs, err := client.LookupMX(context.Background(), "ooni.io")
-	if err == nil {
-		t.Fatal("expected an error here")
+	if err != nil {
+		t.Fatal(err)
 	}
 	for _, addr := range addrs {
 		t.Log(addr)
@@ -90,13 +91,13 @@ func TestLookupMX(t *testing.T) {
 
 func TestLookupNS(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
 	addrs, err := client.LookupNS(context.Background(), "ooni.io")
-	if err == nil {
-		t.Fatal("expected an error here")
+	if err != nil {
+		t.Fatal(err)
 	}
 	for _, addr := range addrs {
 		t.Log(addr)
@@ -105,7 +106,7 @@ func TestLookupNS(t *testing.T) {
 
 func TestRoundTripExPackFailure(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
@@ -114,7 +115,9 @@ func TestRoundTripExPackFailure(t *testing.T) {
 		func(msg *dns.Msg) ([]byte, error) {
 			return nil, errors.New("mocked error")
 		},
-		func(t dnsx.RoundTripper, query []byte) (reply []byte, err error) {
+		func(
+			ctx context.Context, t dnsx.RoundTripper, query []byte,
+		) (reply []byte, err error) {
 			return nil, nil
 		},
 		func(msg *dns.Msg, data []byte) (err error) {
@@ -128,7 +131,7 @@ func TestRoundTripExPackFailure(t *testing.T) {
 
 func TestRoundTripExRoundTripFailure(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
@@ -137,7 +140,9 @@ func TestRoundTripExRoundTripFailure(t *testing.T) {
 		func(msg *dns.Msg) ([]byte, error) {
 			return nil, nil
 		},
-		func(t dnsx.RoundTripper, query []byte) (reply []byte, err error) {
+		func(
+			ctx context.Context, t dnsx.RoundTripper, query []byte,
+		) (reply []byte, err error) {
 			return nil, errors.New("mocked error")
 		},
 		func(msg *dns.Msg, data []byte) (err error) {
@@ -151,7 +156,7 @@ func TestRoundTripExRoundTripFailure(t *testing.T) {
 
 func TestRoundTripExUnpackFailure(t *testing.T) {
 	client := oodns.NewClient(
-		handlers.NoHandler, dnsovertcp.NewTransport(
+		time.Now(), handlers.NoHandler, dnsovertcp.NewTransport(
 			time.Now(), handlers.NoHandler, "dns.quad9.net",
 		),
 	)
@@ -160,7 +165,9 @@ func TestRoundTripExUnpackFailure(t *testing.T) {
 		func(msg *dns.Msg) ([]byte, error) {
 			return nil, nil
 		},
-		func(t dnsx.RoundTripper, query []byte) (reply []byte, err error) {
+		func(
+			ctx context.Context, t dnsx.RoundTripper, query []byte,
+		) (reply []byte, err error) {
 			return nil, nil
 		},
 		func(msg *dns.Msg, data []byte) (err error) {
@@ -191,3 +198,255 @@ func TestLookupHostResultAAAAError(t *testing.T) {
 		t.Fatal("expected nil addrs")
 	}
 }
+
+func TestUnitLookupTypes(t *testing.T) {
+	client := oodns.NewClient(time.Now(), handlers.NoHandler, &fakeTransport{
+		answers: []string{
+			"www.example.com. 3600 IN CNAME example.com.",
+			"example.com. 3600 IN A 93.184.216.34",
+			"example.com. 3600 IN MX 10 mail.example.com.",
+			"example.com. 3600 IN NS a.iana-servers.net.",
+			"example.com. 3600 IN TXT \"v=spf1\" \" -all\"",
+			"_x._tcp.example.com. 3600 IN SRV 1 2 443 srv.example.com.",
+			"34.216.184.93.in-addr.arpa. 3600 IN PTR example.com.",
+		},
+	})
+	ctx := context.Background()
+	cname, err := client.LookupCNAME(ctx, "www.example.com")
+	if err != nil || cname != "example.com." {
+		t.Fatal("LookupCNAME failed")
+	}
+	names, err := client.LookupAddr(ctx, "93.184.216.34")
+	if err != nil || len(names) != 1 || names[0] != "example.com." {
+		t.Fatal("LookupAddr failed")
+	}
+	mx, err := client.LookupMX(ctx, "example.com")
+	if err != nil || len(mx) != 1 || mx[0].Host != "mail.example.com." || mx[0].Pref != 10 {
+		t.Fatal("LookupMX failed")
+	}
+	ns, err := client.LookupNS(ctx, "example.com")
+	if err != nil || len(ns) != 1 || ns[0].Host != "a.iana-servers.net." {
+		t.Fatal("LookupNS failed")
+	}
+	txt, err := client.LookupTXT(ctx, "example.com")
+	if err != nil || len(txt) != 1 || txt[0] != "v=spf1 -all" {
+		t.Fatal("LookupTXT failed")
+	}
+	_, srv, err := client.LookupSRV(ctx, "x", "tcp", "example.com")
+	if err != nil || len(srv) != 1 || srv[0].Port != 443 {
+		t.Fatal("LookupSRV failed")
+	}
+}
+
+func TestUnitLookupHTTPS(t *testing.T) {
+	// priority 1, target ".", alpn=h2,http/1.1 port=8443
+	// ipv4hint=93.184.216.34 ech=0xcafe
+	const rdata = "0001" + "00" +
+		"0001000c" + "026832" + "08687474702f312e31" +
+		"00030002" + "20fb" +
+		"00040004" + "5db8d822" +
+		"00050002" + "cafe"
+	client := oodns.NewClient(time.Now(), handlers.NoHandler, &fakeTransport{
+		answers: []string{
+			"example.com. 3600 IN A 93.184.216.34",
+			"example.com. 3600 IN TYPE65 \\# 39 " + rdata,
+		},
+	})
+	https, err := client.LookupHTTPS(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(https) != 1 {
+		t.Fatal("expected a single HTTPS record")
+	}
+	rr := https[0]
+	if rr.Priority != 1 || rr.Target != "." || rr.Port != 8443 {
+		t.Fatal("unexpected priority, target or port")
+	}
+	if len(rr.ALPN) != 2 || rr.ALPN[0] != "h2" || rr.ALPN[1] != "http/1.1" {
+		t.Fatal("unexpected ALPN")
+	}
+	if len(rr.IPHints) != 1 || rr.IPHints[0].String() != "93.184.216.34" {
+		t.Fatal("unexpected IP hints")
+	}
+	if !bytes.Equal(rr.ECH, []byte{0xca, 0xfe}) || len(rr.Params) != 4 {
+		t.Fatal("unexpected ECH or params")
+	}
+}
+
+func TestUnitParseSVCBInvalid(t *testing.T) {
+	a, _ := dns.NewRR("example.com. 3600 IN A 93.184.216.34")
+	if _, err := oodns.ParseSVCB(a); err == nil {
+		t.Fatal("expected an error here")
+	}
+	for _, rdata := range []string{
+		"00",                 // truncated priority
+		"000100000100",       // truncated param header
+		"0001000001000a",     // truncated param value
+		"000100000100010300", // truncated alpn
+		"00010000030001ff",   // invalid port
+	} {
+		rr, err := dns.NewRR(fmt.Sprintf(
+			"example.com. 3600 IN TYPE64 \\# %d %s", len(rdata)/2, rdata))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := oodns.ParseSVCB(rr); err == nil {
+			t.Fatalf("expected an error for %s", rdata)
+		}
+	}
+}
+
+func TestUnitLookupNoAnswer(t *testing.T) {
+	client := oodns.NewClient(time.Now(), handlers.NoHandler, &fakeTransport{})
+	ctx := context.Background()
+	if _, err := client.LookupAddr(ctx, "93.184.216.34"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if _, err := client.LookupMX(ctx, "example.com"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if _, err := client.LookupNS(ctx, "example.com"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if _, err := client.LookupTXT(ctx, "example.com"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if _, _, err := client.LookupSRV(ctx, "", "", "example.com"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	cname, err := client.LookupCNAME(ctx, "example.com")
+	if err != nil || cname != "example.com." {
+		t.Fatal("expected the name itself to be canonical")
+	}
+}
+
+func TestUnitLookupAddrInvalid(t *testing.T) {
+	client := oodns.NewClient(time.Now(), handlers.NoHandler, &fakeTransport{})
+	if _, err := client.LookupAddr(context.Background(), "antani"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitFollowCNAMEChainLoop(t *testing.T) {
+	a, _ := dns.NewRR("a.example.com. 1 IN CNAME b.example.com.")
+	b, _ := dns.NewRR("b.example.com. 1 IN CNAME a.example.com.")
+	// We just want to make sure we terminate
+	oodns.FollowCNAMEChain("a.example.com.", []dns.RR{a, b})
+}
+
+// fakeTransport replies to every query with the configured answers.
+type fakeTransport struct {
+	answers []string
+}
+
+func (ft *fakeTransport) RoundTrip(query []byte) ([]byte, error) {
+	return ft.RoundTripContext(context.Background(), query)
+}
+
+func (ft *fakeTransport) RoundTripContext(
+	ctx context.Context, query []byte,
+) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	reply := new(dns.Msg)
+	reply.SetReply(msg)
+	for _, s := range ft.answers {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, err
+		}
+		reply.Answer = append(reply.Answer, rr)
+	}
+	return reply.Pack()
+}
+
+func (ft *fakeTransport) Network() string {
+	return "fake"
+}
+
+func (ft *fakeTransport) Address() string {
+	return "127.0.0.1:53"
+}
+
+// plainTransport only implements the mandatory dnsx.RoundTripper
+// methods, so that we exercise the fallback code paths.
+type plainTransport struct {
+	ft fakeTransport
+}
+
+func (pt *plainTransport) RoundTrip(query []byte) ([]byte, error) {
+	return pt.ft.RoundTrip(query)
+}
+
+func TestUnitPlainRoundTripper(t *testing.T) {
+	handler := &savingHandler{}
+	client := oodns.NewClient(time.Now(), handler, &plainTransport{
+		ft: fakeTransport{
+			answers: []string{"example.com. 3600 IN A 93.184.216.34"},
+		},
+	})
+	addrs, err := client.LookupHost(context.Background(), "example.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != "93.184.216.34" {
+		t.Fatal("LookupHost failed")
+	}
+	var count int
+	for _, m := range handler.measurements {
+		if ev := m.DNSRoundTrip; ev != nil {
+			if ev.Transport != "" || ev.ServerAddress != "" {
+				t.Fatal("expected empty transport fields")
+			}
+			count++
+		}
+	}
+	if count == 0 {
+		t.Fatal("no DNSRoundTripEvent emitted")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.LookupHost(ctx, "example.com"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitDNSRoundTripEvent(t *testing.T) {
+	handler := &savingHandler{}
+	client := oodns.NewClient(time.Now(), handler, &fakeTransport{
+		answers: []string{"example.com. 3600 IN A 93.184.216.34"},
+	})
+	_, err := client.Lookup(context.Background(), "example.com", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev *model.DNSRoundTripEvent
+	for _, m := range handler.measurements {
+		if m.DNSRoundTrip != nil {
+			ev = m.DNSRoundTrip
+		}
+	}
+	if ev == nil {
+		t.Fatal("no DNSRoundTripEvent emitted")
+	}
+	if ev.QueryName != "example.com." || ev.QueryType != "A" {
+		t.Fatal("unexpected query fields")
+	}
+	if ev.Rcode != "NOERROR" || len(ev.Answers) != 1 {
+		t.Fatal("unexpected reply fields")
+	}
+	if ev.Transport != "fake" || ev.ServerAddress != "127.0.0.1:53" {
+		t.Fatal("unexpected transport fields")
+	}
+	if len(ev.Query.Data) == 0 || len(ev.Reply.Data) == 0 || ev.ConnID == 0 {
+		t.Fatal("unexpected raw fields")
+	}
+}
+
+type savingHandler struct {
+	measurements []model.Measurement
+}
+
+func (h *savingHandler) OnMeasurement(m model.Measurement) {
+	h.measurements = append(h.measurements, m)
+}
@@ -0,0 +1,196 @@
+// Package proxyhandshake implements the client side of the SOCKS5
+// and HTTP CONNECT handshakes over an already established connection.
+//
+// We perform the handshakes ourselves, rather than using a proxy
+// aware dialer, so that the dialer can measure separately connecting
+// to the proxy and performing the handshake with it. Also, since the
+// connection is a measuring connection, we can see all the I/O events
+// that occur when tunneling traffic through the proxy.
+package proxyhandshake
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var (
+	errSOCKS5AuthFailed    = errors.New("proxyhandshake: SOCKS5 authentication failed")
+	errSOCKS5BadVersion    = errors.New("proxyhandshake: unexpected SOCKS version")
+	errSOCKS5NoMethod      = errors.New("proxyhandshake: no acceptable SOCKS5 auth method")
+	errSOCKS5BadAddrType   = errors.New("proxyhandshake: unexpected SOCKS5 address type")
+	errSOCKS5NameTooLong   = errors.New("proxyhandshake: SOCKS5 host name too long")
+	errSOCKS5CredsTooLong  = errors.New("proxyhandshake: SOCKS5 credentials too long")
+	errHTTPUnexpectedBytes = errors.New("proxyhandshake: data after CONNECT response")
+)
+
+// SOCKS5 performs a SOCKS5 handshake (RFC 1928) over conn, asking the
+// proxy to connect to address, which is a host, port endpoint. When the
+// host is a domain name, the proxy will resolve it. When user is not
+// nil, we use username and password authentication (RFC 1929).
+func SOCKS5(conn net.Conn, address string, user *url.Userinfo) error {
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		return err
+	}
+	methods := []byte{0x00} // no authentication required
+	if user != nil {
+		methods = []byte{0x02} // username and password
+	}
+	_, err = conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errSOCKS5BadVersion
+	}
+	if reply[1] != methods[0] {
+		return errSOCKS5NoMethod
+	}
+	if user != nil {
+		if err = socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	}
+	request := []byte{0x05, 0x01, 0x00} // CONNECT
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(append(request, 0x01), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, 0x04), ip.To16()...)
+	} else if len(host) > 255 {
+		return errSOCKS5NameTooLong
+	} else {
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != 0x05 {
+		return errSOCKS5BadVersion
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("proxyhandshake: SOCKS5 request failed: %s", socks5Reply(header[1]))
+	}
+	var length int
+	switch header[3] {
+	case 0x01:
+		length = net.IPv4len
+	case 0x04:
+		length = net.IPv6len
+	case 0x03:
+		size := make([]byte, 1)
+		if _, err = io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		length = int(size[0])
+	default:
+		return errSOCKS5BadAddrType
+	}
+	// Discard the bound address and port, which we don't need.
+	_, err = io.ReadFull(conn, make([]byte, length+2))
+	return err
+}
+
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errSOCKS5CredsTooLong
+	}
+	request := []byte{0x01, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return errSOCKS5AuthFailed
+	}
+	return nil
+}
+
+func socks5Reply(code byte) string {
+	messages := map[byte]string{
+		0x01: "general SOCKS server failure",
+		0x02: "connection not allowed by ruleset",
+		0x03: "network unreachable",
+		0x04: "host unreachable",
+		0x05: "connection refused",
+		0x06: "TTL expired",
+		0x07: "command not supported",
+		0x08: "address type not supported",
+	}
+	if message, found := messages[code]; found {
+		return message
+	}
+	return "unknown code " + strconv.Itoa(int(code))
+}
+
+// HTTPConnect performs an HTTP CONNECT handshake over conn, asking the
+// proxy to connect to address, which is a host, port endpoint. When
+// user is not nil, we use basic authentication.
+func HTTPConnect(conn net.Conn, address string, user *url.Userinfo) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString(
+			[]byte(user.Username()+":"+password),
+		))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return fmt.Errorf("proxyhandshake: CONNECT failed: %s", resp.Status)
+	}
+	// We cannot give the bytes we have buffered back to the caller,
+	// but this should not happen, since the client speaks first
+	// when using both HTTP and TLS.
+	if reader.Buffered() > 0 {
+		return errHTTPUnexpectedBytes
+	}
+	return nil
+}
+
+func splitHostPort(address string) (string, int, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	portnum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, int(portnum), nil
+}
@@ -0,0 +1,157 @@
+package proxyhandshake_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ooni/netx/internal/proxyhandshake"
+)
+
+func TestUnitSOCKS5Success(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	request := make(chan []byte, 1)
+	go func() {
+		defer server.Close()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(server, greeting); err != nil {
+			return
+		}
+		server.Write([]byte{0x05, 0x00})
+		// VER CMD RSV ATYP LEN "ooni.io" PORT
+		connect := make([]byte, 4+1+7+2)
+		if _, err := io.ReadFull(server, connect); err != nil {
+			return
+		}
+		request <- connect
+		server.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 80})
+	}()
+	err := proxyhandshake.SOCKS5(client, "ooni.io:443", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{0x05, 0x01, 0x00, 0x03, 7}, "ooni.io"...)
+	expected = append(expected, 0x01, 0xbb)
+	if !bytes.Equal(<-request, expected) {
+		t.Fatal("unexpected CONNECT request")
+	}
+}
+
+func TestUnitSOCKS5Auth(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(server, greeting); err != nil {
+			return
+		}
+		server.Write([]byte{0x05, 0x02})
+		// VER ULEN "user" PLEN "pass"
+		auth := make([]byte, 1+1+4+1+4)
+		if _, err := io.ReadFull(server, auth); err != nil {
+			return
+		}
+		server.Write([]byte{0x01, 0x01}) // authentication failure
+	}()
+	err := proxyhandshake.SOCKS5(
+		client, "8.8.8.8:53", url.UserPassword("user", "pass"),
+	)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitSOCKS5Refused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(server, greeting); err != nil {
+			return
+		}
+		server.Write([]byte{0x05, 0x00})
+		// VER CMD RSV ATYP IPv6 PORT
+		connect := make([]byte, 4+16+2)
+		if _, err := io.ReadFull(server, connect); err != nil {
+			return
+		}
+		server.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	}()
+	err := proxyhandshake.SOCKS5(client, "[::1]:443", nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitSOCKS5NoAcceptableMethod(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(server, greeting); err != nil {
+			return
+		}
+		server.Write([]byte{0x05, 0xff})
+	}()
+	err := proxyhandshake.SOCKS5(client, "8.8.8.8:53", nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitSOCKS5InvalidAddress(t *testing.T) {
+	client, _ := net.Pipe()
+	defer client.Close()
+	err := proxyhandshake.SOCKS5(client, "8.8.8.8", nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitHTTPConnectSuccess(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	header := make(chan http.Header, 1)
+	go func() {
+		defer server.Close()
+		req, err := http.ReadRequest(bufio.NewReader(server))
+		if err != nil {
+			return
+		}
+		header <- req.Header
+		server.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	}()
+	err := proxyhandshake.HTTPConnect(
+		client, "ooni.io:443", url.UserPassword("user", "pass"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (<-header).Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+		t.Fatal("unexpected Proxy-Authorization header")
+	}
+}
+
+func TestUnitHTTPConnectFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(server)); err != nil {
+			return
+		}
+		server.Write([]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n"))
+	}()
+	err := proxyhandshake.HTTPConnect(client, "ooni.io:443", nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -0,0 +1,131 @@
+// Package utlsx performs TLS handshakes using github.com/refraction-networking/utls
+// so that our ClientHello looks like the one sent by a specific browser
+// rather than like the one of crypto/tls, which is easy to fingerprint.
+package utlsx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// fingerprints maps the fingerprint names we support to the
+// corresponding uTLS ClientHello IDs.
+var fingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"ios":     utls.HelloIOS_Auto,
+	"safari":  utls.HelloSafari_Auto,
+}
+
+var errUnknownFingerprint = errors.New("utlsx: unknown fingerprint")
+
+// Handshaker performs TLS handshakes mimicking a specific browser.
+type Handshaker struct {
+	ClientHelloID utls.ClientHelloID
+}
+
+// NewHandshaker creates a new Handshaker for the specified fingerprint
+// name. The names we support are "chrome", "edge", "firefox", "ios",
+// and "safari". Each name selects the most recent version of such
+// browser known to uTLS.
+func NewHandshaker(fingerprint string) (*Handshaker, error) {
+	id, ok := fingerprints[fingerprint]
+	if !ok {
+		return nil, errUnknownFingerprint
+	}
+	return &Handshaker{ClientHelloID: id}, nil
+}
+
+// Fingerprint returns the name and version of the fingerprint
+// we are using (e.g., "Chrome-133").
+func (h *Handshaker) Fingerprint() string {
+	return h.ClientHelloID.Str()
+}
+
+// Handshake implements model.TLSHandshaker.Handshake. It performs the
+// TLS handshake over conn using the relevant settings of config. When
+// config.NextProtos is not empty, we advertise such protocols in the
+// ALPN extension, rather than the ones used by the browser, so that
+// the caller can speak the negotiated protocol. In case of certificate
+// verification failure, we return the error as a
+// *tls.CertificateVerificationError, and, when the server rejects
+// ECH, as a *tls.ECHRejectionError, like crypto/tls would do.
+func (h *Handshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	uconfig := &utls.Config{
+		EncryptedClientHelloConfigList: config.EncryptedClientHelloConfigList,
+		InsecureSkipVerify:             config.InsecureSkipVerify,
+		KeyLogWriter:                   config.KeyLogWriter,
+		NextProtos:                     config.NextProtos,
+		RootCAs:                        config.RootCAs,
+		ServerName:                     config.ServerName,
+		Time:                           config.Time,
+	}
+	uconn, err := newUConn(conn, uconfig, h.ClientHelloID)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	err = uconn.HandshakeContext(ctx)
+	state := connectionState(uconn.ConnectionState())
+	var verificationError *utls.CertificateVerificationError
+	if errors.As(err, &verificationError) {
+		err = &tls.CertificateVerificationError{
+			Err:                    verificationError.Err,
+			UnverifiedCertificates: verificationError.UnverifiedCertificates,
+		}
+	}
+	var echRejectionError *utls.ECHRejectionError
+	if errors.As(err, &echRejectionError) {
+		err = &tls.ECHRejectionError{
+			RetryConfigList: echRejectionError.RetryConfigList,
+		}
+	}
+	if err != nil {
+		return nil, state, err
+	}
+	return uconn, state, nil
+}
+
+func newUConn(
+	conn net.Conn, config *utls.Config, id utls.ClientHelloID,
+) (*utls.UConn, error) {
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.NextProtos) > 0 {
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = config.NextProtos
+			}
+		}
+	}
+	uconn := utls.UClient(conn, config, utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return nil, err
+	}
+	return uconn, nil
+}
+
+func connectionState(state utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		CipherSuite:                 state.CipherSuite,
+		DidResume:                   state.DidResume,
+		ECHAccepted:                 state.ECHAccepted,
+		HandshakeComplete:           state.HandshakeComplete,
+		NegotiatedProtocol:          state.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  state.NegotiatedProtocolIsMutual,
+		OCSPResponse:                state.OCSPResponse,
+		PeerCertificates:            state.PeerCertificates,
+		ServerName:                  state.ServerName,
+		SignedCertificateTimestamps: state.SignedCertificateTimestamps,
+		VerifiedChains:              state.VerifiedChains,
+		Version:                     state.Version,
+	}
+}
@@ -0,0 +1,104 @@
+package utlsx_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/netx/internal/clienthello"
+	"github.com/ooni/netx/internal/utlsx"
+)
+
+func handshake(
+	t *testing.T, fingerprint string, config *tls.Config,
+) (*clienthello.Recorder, net.Conn, tls.ConnectionState, error) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	handshaker, err := utlsx.NewHandshaker(fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if config.RootCAs == nil && !config.InsecureSkipVerify {
+		config.RootCAs = x509.NewCertPool()
+		config.RootCAs.AddCert(server.Certificate())
+	}
+	config.ServerName = "example.com"
+	recorder := &clienthello.Recorder{Conn: conn}
+	tc, state, err := handshaker.Handshake(context.Background(), recorder, config)
+	return recorder, tc, state, err
+}
+
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func TestUnitHandshake(t *testing.T) {
+	recorder, conn, state, err := handshake(t, "chrome", &tls.Config{
+		NextProtos: []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == nil || len(state.VerifiedChains) != 1 {
+		t.Fatal("expected a verified conn")
+	}
+	if state.NegotiatedProtocol != "h2" {
+		t.Fatal("unexpected negotiated protocol")
+	}
+	hello := recorder.ClientHello()
+	if hello == nil {
+		t.Fatal("cannot parse the ClientHello")
+	}
+	if !isGREASE(hello.CipherSuites[0]) {
+		t.Fatal("expected a Chrome-like ClientHello")
+	}
+	if len(hello.ALPN) != 1 || hello.ALPN[0] != "h2" {
+		t.Fatal("expected our ALPN protocols")
+	}
+}
+
+func TestUnitHandshakeVerificationError(t *testing.T) {
+	_, conn, state, err := handshake(t, "firefox", &tls.Config{
+		RootCAs: x509.NewCertPool(),
+	})
+	var verificationError *tls.CertificateVerificationError
+	if !errors.As(err, &verificationError) {
+		t.Fatal("expected a verification error")
+	}
+	if len(verificationError.UnverifiedCertificates) != 1 {
+		t.Fatal("expected the unverified certificates")
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) {
+		t.Fatal("expected an unknown authority error")
+	}
+	if conn != nil || len(state.VerifiedChains) != 0 {
+		t.Fatal("expected no conn and no verified chains")
+	}
+}
+
+func TestUnitNewHandshaker(t *testing.T) {
+	handshaker, err := utlsx.NewHandshaker("chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handshaker.Fingerprint() == "" {
+		t.Fatal("expected a fingerprint")
+	}
+	_, err = utlsx.NewHandshaker("antani")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -0,0 +1,122 @@
+// Package jsonl reads back the measurements emitted as JSONL, e.g.
+// by handlers.StdoutHandler, and replays them into a model.Handler.
+//
+// We are lenient with respect to the input format. Measurements do
+// not need to be on a single line, so pretty printed traces such as
+// testdata/demo1.jsonl are fine. Top level values that are not JSON
+// objects (e.g., the string with the command line at the beginning
+// of testdata/demo1.jsonl) are treated as comments and skipped.
+package jsonl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/ooni/netx/model"
+)
+
+// Reader reads measurements from a JSONL stream.
+type Reader struct {
+	decoder *json.Decoder
+}
+
+// NewReader creates a new Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{decoder: json.NewDecoder(r)}
+}
+
+// Read returns the next measurement. It returns io.EOF when there
+// are no more measurements in the stream.
+func (r *Reader) Read() (m model.Measurement, err error) {
+	for {
+		var raw json.RawMessage
+		if err = r.decoder.Decode(&raw); err != nil {
+			return
+		}
+		if bytes.HasPrefix(raw, []byte("{")) {
+			err = json.Unmarshal(raw, &m)
+			return
+		}
+	}
+}
+
+// ReadAll reads all the measurements in r.
+func ReadAll(r io.Reader) (out []model.Measurement, err error) {
+	reader := NewReader(r)
+	for {
+		var m model.Measurement
+		m, err = reader.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+}
+
+// Replay reads measurements from r and passes them to handler. When
+// realtime is true, we honour the spacing between measurements using
+// their Time. Otherwise, we replay the measurements as fast as we can.
+// We stop when we reach the end of the stream, when the stream cannot
+// be parsed, or when ctx is done. We return nil when we reach the end.
+func Replay(
+	ctx context.Context, r io.Reader, handler model.Handler, realtime bool,
+) error {
+	reader := NewReader(r)
+	start := time.Now()
+	var zero time.Duration
+	for first := true; ; first = false {
+		m, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if realtime {
+			elapsed := MeasurementTime(m)
+			if first {
+				zero = elapsed
+			}
+			timer := time.NewTimer(start.Add(elapsed - zero).Sub(time.Now()))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		handler.OnMeasurement(m)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// MeasurementTime returns the Time of the measurement. Since a
+// measurement may contain several events, we return the largest
+// Time. You generally only care about this function when writing
+// tests or when you want to implement your own replay logic.
+func MeasurementTime(m model.Measurement) (t time.Duration) {
+	value := reflect.ValueOf(m)
+	for i := 0; i < value.NumField(); i++ {
+		event := value.Field(i)
+		if event.Kind() != reflect.Ptr || event.IsNil() {
+			continue
+		}
+		field := event.Elem().FieldByName("Time")
+		if field.IsValid() && field.Type() == durationType {
+			if current := time.Duration(field.Int()); current > t {
+				t = current
+			}
+		}
+	}
+	return
+}
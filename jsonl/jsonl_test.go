@@ -0,0 +1,139 @@
+package jsonl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ooni/netx/jsonl"
+	"github.com/ooni/netx/model"
+)
+
+func TestUnitReadAllDemo(t *testing.T) {
+	for _, path := range []string{
+		"../testdata/demo1.jsonl", "../testdata/demo2.jsonl",
+	} {
+		filep, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		measurements, err := jsonl.ReadAll(filep)
+		filep.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(measurements) < 1 {
+			t.Fatal("expected some measurements")
+		}
+		if measurements[0].Connect == nil {
+			t.Fatal("expected the first measurement to be a Connect")
+		}
+	}
+}
+
+func newTrace(t *testing.T, times ...time.Duration) *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	buffer.WriteString("\"# this is a comment\"\n")
+	for i, elapsed := range times {
+		data, err := json.Marshal(model.Measurement{
+			Read: &model.ReadEvent{
+				ConnID:   int64(i),
+				NumBytes: 128,
+				Time:     elapsed,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer.Write(data)
+		buffer.WriteString("\n")
+	}
+	return buffer
+}
+
+func TestUnitReadAllInvalid(t *testing.T) {
+	_, err := jsonl.ReadAll(strings.NewReader(`{"Version":1}{`))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+type savingHandler struct {
+	measurements []model.Measurement
+	mutex        sync.Mutex
+}
+
+func (h *savingHandler) OnMeasurement(m model.Measurement) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.measurements = append(h.measurements, m)
+}
+
+func TestUnitReplay(t *testing.T) {
+	handler := &savingHandler{}
+	trace := newTrace(t, 10*time.Second, 20*time.Second, 30*time.Second)
+	start := time.Now()
+	err := jsonl.Replay(context.Background(), trace, handler, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Now().Sub(start) > time.Second {
+		t.Fatal("replay should not have honoured the time spacing")
+	}
+	if len(handler.measurements) != 3 {
+		t.Fatal("unexpected number of measurements")
+	}
+	for i, m := range handler.measurements {
+		if m.Read == nil || m.Read.ConnID != int64(i) {
+			t.Fatal("unexpected measurement")
+		}
+	}
+}
+
+func TestUnitReplayRealtime(t *testing.T) {
+	handler := &savingHandler{}
+	trace := newTrace(
+		t, time.Second, time.Second+100*time.Millisecond,
+		time.Second+200*time.Millisecond,
+	)
+	start := time.Now()
+	err := jsonl.Replay(context.Background(), trace, handler, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Now().Sub(start) < 200*time.Millisecond {
+		t.Fatal("replay should have honoured the time spacing")
+	}
+	if len(handler.measurements) != 3 {
+		t.Fatal("unexpected number of measurements")
+	}
+}
+
+func TestUnitReplayCancel(t *testing.T) {
+	handler := &savingHandler{}
+	trace := newTrace(t, 0, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := jsonl.Replay(ctx, trace, handler, true)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected the context deadline to expire")
+	}
+	if len(handler.measurements) != 1 {
+		t.Fatal("unexpected number of measurements")
+	}
+}
+
+func TestUnitMeasurementTime(t *testing.T) {
+	elapsed := jsonl.MeasurementTime(model.Measurement{
+		DNSQuery: &model.DNSQueryEvent{Time: time.Second},
+		DNSReply: &model.DNSReplyEvent{Time: 2 * time.Second},
+	})
+	if elapsed != 2*time.Second {
+		t.Fatal("unexpected measurement time")
+	}
+}
@@ -2,9 +2,12 @@
 // using a unique int64 ConnID. HTTP events also have a unique int64
 // ID, TransactionID. These IDs are never reused.
 //
-// To join network events and HTTP events, use the LocalAddress and
-// RemoteAddress that are included both in the ConnectEvent and in
-// the HTTPConnectionReadyEvent.
+// HTTP events also carry the ConnID of the connection used by the
+// transaction, so network and HTTP events can be joined directly. The
+// ConnID is zero when it could not be determined (e.g. when the
+// connection was not created by our dialer). In such case, you can
+// still join events using the LocalAddress and RemoteAddress that are
+// included both in the ConnectEvent and in the HTTPConnectionReadyEvent.
 //
 // All events also have a Time. This is always the time in which
 // an event has been emitted. We use a monotonic clock. Hence, the
@@ -15,27 +18,58 @@
 // ReadEvent.Duration indicates for how long the code has
 // been blocked inside Read().
 //
-// When an operation may fail, we also include the Error.
+// When an operation may fail, we also include the Error. Since
+// the Error varies by platform and does not serialize to JSON, we
+// also include the Failure, i.e., a stable string that classifies
+// the Error (e.g., "connection_reset"). The Failure is empty when
+// the Error is nil.
 package model
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
 	"net/http"
+	"reflect"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
-// CloseEvent is emitted when conn.Close returns.
+// CloseEvent is emitted when conn.Close returns. We also emit it
+// when we close a connection that lost the happy eyeballs race, in
+// which case the Error is ErrDiscarded. The LocalAddress and the
+// RemoteAddress are the ones of the ConnectEvent, so you can tell
+// which connection has been closed when several of them share the
+// same ConnID.
 type CloseEvent struct {
-	ConnID   int64
-	Duration time.Duration
-	Error    error
-	Time     time.Duration
+	ConnID        int64
+	Duration      time.Duration
+	Error         error
+	Failure       string
+	LocalAddress  string
+	RemoteAddress string
+	Time          time.Duration
 }
 
-// ConnectEvent is emitted when connect() returns.
+// ErrDiscarded is the Error of the CloseEvent emitted when we close
+// a connection that lost the happy eyeballs race.
+var ErrDiscarded = errors.New("model: connection discarded")
+
+// ConnectEvent is emitted when connect() returns. With happy eyeballs
+// (see DialEvent), all the attempts share the same ConnID, and more
+// than one of them may succeed, so do not assume that there is a
+// single successful ConnectEvent per ConnID. We only keep the
+// connection to the RemoteAddress of the DialEvent and emit a
+// CloseEvent with ErrDiscarded for each of the others.
 type ConnectEvent struct {
 	ConnID        int64
 	Duration      time.Duration
 	Error         error
+	Failure       string
 	LocalAddress  string
 	Network       string
 	RemoteAddress string
@@ -61,8 +95,165 @@ type DNSReplyEvent struct {
 	Time    time.Duration
 }
 
+// DNSRoundTripEvent is emitted when a DNS round trip completes. It
+// contains the most important fields of the query and of the reply, so
+// that you don't need to parse the messages, as well as the messages.
+type DNSRoundTripEvent struct {
+	// Answers contains the answers in presentation format.
+	Answers []string
+
+	// ConnID is the ConnID also used by DNSQueryEvent and DNSReplyEvent.
+	ConnID int64
+
+	// Duration is the duration of the round trip. When the "udp"
+	// transport waits for duplicate replies (see SetDNSDuplicatesWindow
+	// in netx), this includes the time spent waiting for them, so use
+	// the DNSReplyEvents to know when each reply was received.
+	Duration time.Duration
+
+	// Error is the error that occurred, if any. A reply whose Rcode
+	// is not "NOERROR" is not an error in this context.
+	Error error
+
+	// Failure is the classification of Error.
+	Failure string
+
+	// MessageID is the ID of the query (aka transaction ID).
+	MessageID uint16
+
+	// Query is the query we have sent.
+	Query DNSMessage
+
+	// QueryName is the name we have queried for.
+	QueryName string
+
+	// QueryType is the query type (e.g., "AAAA").
+	QueryType string
+
+	// Rcode is the reply rcode (e.g., "NXDOMAIN"). It is empty
+	// if we have not received any reply.
+	Rcode string
+
+	// Reply is the reply we have received, if any.
+	Reply DNSMessage
+
+	// ServerAddress is the address of the server. Its format
+	// depends on the transport (e.g., a URL for "doh").
+	ServerAddress string
+
+	// Time is the time when the round trip completed.
+	Time time.Duration
+
+	// Transport is the transport name (e.g., "dot").
+	Transport string
+}
+
+// DialAttempt is an attempt to connect to one of the addresses to
+// which the hostname passed to Dial resolved.
+type DialAttempt struct {
+	Address  string
+	Duration time.Duration
+	Error    error
+	Failure  string
+}
+
+// DialError is the error returned by Dial when all the attempts
+// to connect to the addresses of a hostname have failed. It allows
+// to know which address failed with which error.
+type DialError struct {
+	Attempts []DialAttempt
+	Hostname string
+	Network  string
+}
+
+// Error returns a string representation of the error.
+func (e *DialError) Error() string {
+	s := "dial " + e.Network + " " + e.Hostname + ": all connect attempts failed"
+	for _, attempt := range e.Attempts {
+		s += "; " + attempt.Address + ": "
+		if attempt.Error != nil {
+			s += attempt.Error.Error()
+		} else {
+			s += "<nil>"
+		}
+	}
+	return s
+}
+
+// Unwrap returns the errors of the attempts, so that errors.Is and
+// errors.As can inspect them.
+func (e *DialError) Unwrap() []error {
+	var errs []error
+	for _, attempt := range e.Attempts {
+		if attempt.Error != nil {
+			errs = append(errs, attempt.Error)
+		}
+	}
+	return errs
+}
+
+// Timeout returns true when all the attempts failed with a timeout.
+func (e *DialError) Timeout() bool {
+	return e.all(func(err net.Error) bool { return err.Timeout() })
+}
+
+// Temporary returns true when all the attempts failed with a
+// temporary error. Like net.Error.Temporary, it is deprecated.
+func (e *DialError) Temporary() bool {
+	return e.all(func(err net.Error) bool { return err.Temporary() })
+}
+
+func (e *DialError) all(predicate func(err net.Error) bool) bool {
+	for _, attempt := range e.Attempts {
+		var netError net.Error
+		if !errors.As(attempt.Error, &netError) || !predicate(netError) {
+			return false
+		}
+	}
+	return len(e.Attempts) > 0
+}
+
+// TLSVerificationError is the error returned when the TLS handshake
+// succeeded but we could not verify the certificate chain presented by
+// the server. We only return this error when verification is separate
+// from the handshake; otherwise, the handshake itself fails. Err is
+// the underlying x509 error.
+type TLSVerificationError struct {
+	Err error
+}
+
+// Error returns a string representation of the error.
+func (e *TLSVerificationError) Error() string {
+	return "tls: cannot verify certificate: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TLSVerificationError) Unwrap() error {
+	return e.Err
+}
+
+// DialEvent is emitted when dialing a hostname, rather than an IP
+// address, returns. It summarizes the attempts to connect to the
+// addresses of the hostname, in the order in which we have tried
+// them. Each attempt has also emitted a ConnectEvent. The
+// RemoteAddress is the address we connected to, if any. The Error is
+// the error returned by Dial, if any. When the hostname resolution
+// fails, there are no attempts and the Error is the resolver error.
+type DialEvent struct {
+	Attempts      []DialAttempt
+	ConnID        int64
+	Duration      time.Duration
+	Error         error
+	Failure       string
+	Hostname      string
+	Network       string
+	RemoteAddress string
+	Time          time.Duration
+}
+
 // HTTPConnectionReadyEvent is emitted when a connection is ready for HTTP.
 type HTTPConnectionReadyEvent struct {
+	ConnID        int64
 	LocalAddress  string
 	Network       string
 	RemoteAddress string
@@ -72,12 +263,17 @@ type HTTPConnectionReadyEvent struct {
 
 // HTTPRequestStartEvent is emitted when we start sending the request.
 type HTTPRequestStartEvent struct {
+	ConnID        int64
 	Time          time.Duration
 	TransactionID int64
 }
 
 // HTTPRequestHeadersDoneEvent is emitted when we have written the headers.
+// FrontDomain is the domain we connected to when using domain fronting,
+// in which case the URL contains the host we are fronting.
 type HTTPRequestHeadersDoneEvent struct {
+	ConnID        int64
+	FrontDomain   string
 	Headers       http.Header
 	Method        string
 	Time          time.Duration
@@ -87,18 +283,21 @@ type HTTPRequestHeadersDoneEvent struct {
 
 // HTTPRequestDoneEvent is emitted when we have sent the body.
 type HTTPRequestDoneEvent struct {
+	ConnID        int64
 	Time          time.Duration
 	TransactionID int64
 }
 
 // HTTPResponseStartEvent is emitted when we receive the first response byte.
 type HTTPResponseStartEvent struct {
+	ConnID        int64
 	Time          time.Duration
 	TransactionID int64
 }
 
 // HTTPResponseHeadersDoneEvent is emitted after we have received the headers.
 type HTTPResponseHeadersDoneEvent struct {
+	ConnID        int64
 	Headers       http.Header
 	StatusCode    int64
 	Time          time.Duration
@@ -107,15 +306,33 @@ type HTTPResponseHeadersDoneEvent struct {
 
 // HTTPResponseDoneEvent is emitted after we have received the body.
 type HTTPResponseDoneEvent struct {
+	ConnID        int64
 	Time          time.Duration
 	TransactionID int64
 }
 
-// ReadEvent is emitted when conn.Read returns.
+// ProxyHandshakeEvent is emitted when the handshake with a SOCKS5 or
+// HTTP CONNECT proxy returns. The ConnID is the one of the connection
+// with the proxy, which is then used to tunnel traffic.
+type ProxyHandshakeEvent struct {
+	ConnID        int64
+	Duration      time.Duration
+	Error         error
+	Failure       string
+	ProxyAddress  string
+	ProxyType     string
+	TargetAddress string
+	Time          time.Duration
+}
+
+// ReadEvent is emitted when conn.Read returns. The Data is only
+// present when payload capture is enabled and it may be truncated.
 type ReadEvent struct {
 	ConnID   int64
+	Data     []byte
 	Duration time.Duration
 	Error    error
+	Failure  string
 	NumBytes int64
 	Time     time.Duration
 }
@@ -126,14 +343,31 @@ type ResolveEvent struct {
 	ConnID    int64
 	Duration  time.Duration
 	Error     error
+	Failure   string
 	Hostname  string
 	Time      time.Duration
 }
 
-// TLSConfig contains TLS configurations.
+// TLSConfig contains TLS configurations. Fingerprint is the name
+// and version of the browser whose ClientHello we mimic (e.g.,
+// "Chrome-133"), or empty if we are using crypto/tls.
+//
+// ServerName is the name against which we verify the certificate and
+// SNI is the name we send in the ClientHello. They differ when we hide
+// the SNI, and SNI is empty when we send no SNI. When we use Encrypted
+// Client Hello, ECHConfigList is the ECHConfigList we used and SNI is
+// the name in the encrypted ClientHello, while the ClientHello of the
+// TLSConnectionState contains the public name sent in clear.
+//
+// When we use domain fronting, ServerName is the front domain and
+// FrontedHost is the host we want to reach using the front.
 type TLSConfig struct {
-	NextProtos []string
-	ServerName string
+	ECHConfigList []byte
+	Fingerprint   string
+	FrontedHost   string
+	NextProtos    []string
+	SNI           string
+	ServerName    string
 }
 
 // X509Certificate is an x.509 certificate.
@@ -142,13 +376,42 @@ type X509Certificate struct {
 	Data []byte
 }
 
+// TLSClientHello contains the parameters we offered in the ClientHello,
+// as parsed from the ClientHello message we have actually sent.
+type TLSClientHello struct {
+	ALPN                []string
+	CipherSuites        []uint16
+	Extensions          []uint16
+	ServerName          string
+	SignatureAlgorithms []uint16
+	SupportedGroups     []uint16
+	SupportedVersions   []uint16
+	Version             uint16
+}
+
 // TLSConnectionState contains the TLS connection state.
+//
+// When the handshake fails because we cannot verify the certificate,
+// PeerCertificates contains the certificates sent by the server, the
+// VerificationFailure contains the reason (e.g. "ssl_unknown_authority",
+// "ssl_invalid_hostname", or "ssl_invalid_certificate" for expired
+// certificates), and Verified is false. Verified is also false when
+// we skip verification. The ClientHello is empty if we could not parse
+// the ClientHello message we have sent.
 type TLSConnectionState struct {
-	CipherSuite                uint16
-	NegotiatedProtocol         string
-	NegotiatedProtocolIsMutual bool
-	PeerCertificates           []X509Certificate
-	Version                    uint16
+	CipherSuite                 uint16
+	ClientHello                 TLSClientHello
+	DidResume                   bool
+	ECHAccepted                 bool
+	NegotiatedProtocol          string
+	NegotiatedProtocolIsMutual  bool
+	OCSPResponse                []byte
+	PeerCertificates            []X509Certificate
+	SignedCertificateTimestamps [][]byte
+	VerificationFailure         string
+	Verified                    bool
+	VerifiedChains              [][]X509Certificate
+	Version                     uint16
 }
 
 // TLSHandshakeEvent is emitted when conn.Handshake returns.
@@ -158,14 +421,28 @@ type TLSHandshakeEvent struct {
 	ConnID          int64
 	Duration        time.Duration
 	Error           error
+	Failure         string
 	Time            time.Duration
 }
 
-// WriteEvent is emitted when conn.Write returns.
+// TLSKeyLogEvent is emitted when the TLS code writes a line in NSS
+// key log format (without the trailing newline) that allows to decrypt
+// the traffic of a TLS connection. We only emit this event when you
+// configure a KeyLogWriter. Handle it with care: it contains secrets.
+type TLSKeyLogEvent struct {
+	ConnID int64
+	Line   string
+	Time   time.Duration
+}
+
+// WriteEvent is emitted when conn.Write returns. The Data is only
+// present when payload capture is enabled and it may be truncated.
 type WriteEvent struct {
 	ConnID   int64
+	Data     []byte
 	Duration time.Duration
 	Error    error
+	Failure  string
 	NumBytes int64
 	Time     time.Duration
 }
@@ -178,6 +455,8 @@ type Measurement struct {
 	Connect                 *ConnectEvent                 `json:",omitempty"`
 	DNSQuery                *DNSQueryEvent                `json:",omitempty"`
 	DNSReply                *DNSReplyEvent                `json:",omitempty"`
+	DNSRoundTrip            *DNSRoundTripEvent            `json:",omitempty"`
+	Dial                    *DialEvent                    `json:",omitempty"`
 	HTTPConnectionReady     *HTTPConnectionReadyEvent     `json:",omitempty"`
 	HTTPRequestStart        *HTTPRequestStartEvent        `json:",omitempty"`
 	HTTPRequestHeadersDone  *HTTPRequestHeadersDoneEvent  `json:",omitempty"`
@@ -185,9 +464,11 @@ type Measurement struct {
 	HTTPResponseStart       *HTTPResponseStartEvent       `json:",omitempty"`
 	HTTPResponseHeadersDone *HTTPResponseHeadersDoneEvent `json:",omitempty"`
 	HTTPResponseDone        *HTTPResponseDoneEvent        `json:",omitempty"`
+	ProxyHandshake          *ProxyHandshakeEvent          `json:",omitempty"`
 	Read                    *ReadEvent                    `json:",omitempty"`
 	Resolve                 *ResolveEvent                 `json:",omitempty"`
 	TLSHandshake            *TLSHandshakeEvent            `json:",omitempty"`
+	TLSKeyLog               *TLSKeyLogEvent               `json:",omitempty"`
 	Write                   *WriteEvent                   `json:",omitempty"`
 }
 
@@ -199,3 +480,436 @@ type Handler interface {
 	// goroutines and OnMeasurement calls may happen concurrently.
 	OnMeasurement(Measurement)
 }
+
+// TLSHandshaker performs the TLS handshake. The dialer uses it to run
+// the handshake over the connections it creates, so that it is possible
+// to use alternative TLS implementations, to inject faults, or to change
+// how the ClientHello is written into the conn.
+type TLSHandshaker interface {
+	// Handshake performs the TLS handshake over conn using config, and
+	// must honour the context. On success, it returns the TLS conn. It
+	// should always return the connection state, which the dialer uses
+	// to fill the TLSHandshakeEvent. When certificate verification fails,
+	// Handshake should return a *tls.CertificateVerificationError, so that
+	// the event still contains the certificates presented by the server.
+	// The dialer closes conn when Handshake fails.
+	Handshake(
+		ctx context.Context, conn net.Conn, config *tls.Config,
+	) (net.Conn, tls.ConnectionState, error)
+}
+
+// JSONVersion is the version of the JSON encoding of a Measurement. We
+// include it into every serialized Measurement as the Version field, and
+// we refuse to decode measurements using a newer version.
+//
+// In version 1, which is the current version:
+//
+// 1. every time.Duration is a string using the time.Duration.String
+// format (e.g., "1.5ms"), which is parsed by time.ParseDuration;
+//
+// 2. every error is either null or a string containing the error
+// message, which we decode as an error having the same message (the
+// original type is lost, but the Failure allows to classify it);
+//
+// 3. a DNSMessage contains the Data, as base64, and the Text, i.e., a
+// human readable representation of the message, which we ignore
+// when decoding;
+//
+// 4. a X509Certificate contains the PEM encoding of the certificate.
+//
+// All the other fields use the default encoding/json rules.
+//
+// Version 0 is the legacy encoding, without the Version field, that we
+// used before versioning the encoding. We can decode it, but some data
+// is lost: durations are integer nanoseconds, which is fine; errors are
+// JSON objects with no message, which we decode as errors whose message
+// is the JSON object itself; a X509Certificate contains the Data, as
+// base64, rather than the PEM encoding.
+const JSONVersion = 1
+
+var errUnsupportedJSONVersion = errors.New("model: unsupported JSON version")
+
+// MarshalJSON implements json.Marshaler.
+func (m Measurement) MarshalJSON() ([]byte, error) {
+	type measurement Measurement // avoid recursion
+	return json.Marshal(struct {
+		measurement
+		Version int
+	}{measurement(m), JSONVersion})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Measurement) UnmarshalJSON(data []byte) error {
+	type measurement Measurement // avoid recursion
+	var value struct {
+		*measurement
+		Version int
+	}
+	value.measurement = (*measurement)(m)
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Version > JSONVersion {
+		return errUnsupportedJSONVersion
+	}
+	return nil
+}
+
+type dnsMessageJSON struct {
+	Data []byte
+	Text string `json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m DNSMessage) MarshalJSON() ([]byte, error) {
+	value := dnsMessageJSON{Data: m.Data}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(m.Data); err == nil {
+		value.Text = msg.String()
+	}
+	return json.Marshal(value)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *DNSMessage) UnmarshalJSON(data []byte) error {
+	var value dnsMessageJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	m.Data = value.Data
+	return nil
+}
+
+type x509CertificateJSON struct {
+	Data []byte `json:",omitempty"` // version 0
+	PEM  string `json:",omitempty"`
+}
+
+var errInvalidPEM = errors.New("model: invalid PEM certificate")
+
+// MarshalJSON implements json.Marshaler.
+func (c X509Certificate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x509CertificateJSON{PEM: string(pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: c.Data},
+	))})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *X509Certificate) UnmarshalJSON(data []byte) error {
+	var value x509CertificateJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.PEM == "" {
+		c.Data = value.Data
+		return nil
+	}
+	block, _ := pem.Decode([]byte(value.PEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errInvalidPEM
+	}
+	c.Data = block.Bytes
+	return nil
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// marshalEvent encodes the event struct v, using the rules described
+// in the JSONVersion documentation for durations and errors.
+func marshalEvent(v interface{}) ([]byte, error) {
+	value := reflect.ValueOf(v)
+	out := make(map[string]interface{})
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		switch {
+		case field.Type == durationType:
+			out[field.Name] = value.Field(i).Interface().(time.Duration).String()
+		case field.Type == errorType:
+			var message *string
+			if err, _ := value.Field(i).Interface().(error); err != nil {
+				s := err.Error()
+				message = &s
+			}
+			out[field.Name] = message
+		default:
+			out[field.Name] = value.Field(i).Interface()
+		}
+	}
+	return json.Marshal(out)
+}
+
+// unmarshalEvent is the inverse of marshalEvent. The v argument must
+// be a pointer to the event struct. Missing fields are left untouched.
+func unmarshalEvent(data []byte, v interface{}) error {
+	var in map[string]json.RawMessage
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	value := reflect.ValueOf(v).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		raw, found := in[field.Name]
+		if !found {
+			continue
+		}
+		switch {
+		case field.Type == durationType:
+			d, err := unmarshalDuration(raw)
+			if err != nil {
+				return err
+			}
+			value.Field(i).SetInt(int64(d))
+		case field.Type == errorType:
+			decoded, err := unmarshalError(raw)
+			if err != nil {
+				return err
+			}
+			if decoded != nil {
+				value.Field(i).Set(reflect.ValueOf(decoded))
+			} else {
+				value.Field(i).Set(reflect.Zero(errorType))
+			}
+		default:
+			if err := json.Unmarshal(raw, value.Field(i).Addr().Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unmarshalDuration(raw json.RawMessage) (time.Duration, error) {
+	var d time.Duration
+	if err := json.Unmarshal(raw, &d); err == nil {
+		return d, nil // version 0
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(s)
+}
+
+// unmarshalError returns the decoded error value, which may be nil,
+// and the error that occurred when decoding, if any.
+func unmarshalError(raw json.RawMessage) (value error, err error) {
+	var message *string
+	if err := json.Unmarshal(raw, &message); err == nil {
+		if message == nil {
+			return nil, nil
+		}
+		return errors.New(*message), nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(object) // version 0
+	if err != nil {
+		return nil, err
+	}
+	return errors.New(string(data)), nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e CloseEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *CloseEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e ConnectEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *ConnectEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e DNSQueryEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *DNSQueryEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e DNSReplyEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *DNSReplyEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e DNSRoundTripEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *DNSRoundTripEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e DialAttempt) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *DialAttempt) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e DialEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *DialEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPConnectionReadyEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPConnectionReadyEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPRequestStartEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPRequestStartEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPRequestHeadersDoneEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPRequestHeadersDoneEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPRequestDoneEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPRequestDoneEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPResponseStartEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPResponseStartEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPResponseHeadersDoneEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPResponseHeadersDoneEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HTTPResponseDoneEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *HTTPResponseDoneEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e ProxyHandshakeEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *ProxyHandshakeEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e ReadEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *ReadEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e ResolveEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *ResolveEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e TLSHandshakeEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *TLSHandshakeEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e TLSKeyLogEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *TLSKeyLogEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e WriteEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *WriteEvent) UnmarshalJSON(data []byte) error {
+	return unmarshalEvent(data, e)
+}
@@ -0,0 +1,226 @@
+package model_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/netx/model"
+)
+
+func newMeasurements(t *testing.T) []model.Measurement {
+	query := new(dns.Msg)
+	query.SetQuestion("ooni.io.", dns.TypeA)
+	data, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	return []model.Measurement{{
+		Close: &model.CloseEvent{
+			ConnID:   1,
+			Duration: 1234567891 * time.Nanosecond,
+			Error:    errors.New("antani"),
+			Failure:  "unknown_failure: antani",
+			Time:     17 * time.Millisecond,
+		},
+	}, {
+		DNSQuery: &model.DNSQueryEvent{
+			ConnID:  2,
+			Message: model.DNSMessage{Data: data},
+			Time:    time.Second,
+		},
+		DNSReply: &model.DNSReplyEvent{
+			ConnID:  2,
+			Message: model.DNSMessage{Data: []byte{0, 1, 2}},
+			Time:    time.Second,
+		},
+	}, {
+		Dial: &model.DialEvent{
+			Attempts: []model.DialAttempt{{
+				Address:  "127.0.0.1:443",
+				Duration: time.Millisecond,
+				Error:    errors.New("connection refused"),
+				Failure:  "connection_refused",
+			}, {
+				Address:  "[::1]:443",
+				Duration: 2 * time.Millisecond,
+			}},
+			ConnID:        3,
+			Duration:      3 * time.Millisecond,
+			Hostname:      "localhost",
+			Network:       "tcp",
+			RemoteAddress: "[::1]:443",
+			Time:          4 * time.Millisecond,
+		},
+	}, {
+		HTTPRequestHeadersDone: &model.HTTPRequestHeadersDoneEvent{
+			ConnID: 4,
+			Headers: http.Header{
+				"Accept": []string{"*/*"},
+			},
+			Method:        "GET",
+			Time:          time.Minute,
+			TransactionID: 1,
+			URL:           "https://ooni.io/",
+		},
+	}, {
+		TLSHandshake: &model.TLSHandshakeEvent{
+			Config: model.TLSConfig{
+				NextProtos: []string{"h2", "http/1.1"},
+				ServerName: "ooni.io",
+			},
+			ConnectionState: model.TLSConnectionState{
+				CipherSuite: 0x1301,
+				PeerCertificates: []model.X509Certificate{{
+					Data: server.Certificate().Raw,
+				}},
+				Version: 0x0304,
+			},
+			ConnID:   5,
+			Duration: time.Microsecond,
+			Time:     time.Hour,
+		},
+	}}
+}
+
+func TestUnitJSONRoundTrip(t *testing.T) {
+	for _, m := range newMeasurements(t) {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded model.Measurement
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		// Errors cannot be compared directly, so we compare their
+		// message by serializing again and we compare the other
+		// fields after clearing errors in both measurements.
+		again, err := json.Marshal(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Fatalf("%s != %s", string(data), string(again))
+		}
+		if !reflect.DeepEqual(clearErrors(m), clearErrors(decoded)) {
+			t.Fatalf("%s: the decoded measurement is different", string(data))
+		}
+	}
+}
+
+func clearErrors(m model.Measurement) model.Measurement {
+	if m.Close != nil {
+		ev := *m.Close
+		ev.Error, m.Close = nil, &ev
+	}
+	if m.Dial != nil {
+		ev := *m.Dial
+		ev.Attempts = append([]model.DialAttempt{}, ev.Attempts...)
+		for i := range ev.Attempts {
+			ev.Attempts[i].Error = nil
+		}
+		ev.Error, m.Dial = nil, &ev
+	}
+	return m
+}
+
+func TestUnitJSONEncoding(t *testing.T) {
+	data, err := json.Marshal(newMeasurements(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(data)
+	for _, expected := range []string{
+		`"Version":1`,
+		`"Duration":"1.234567891s"`,
+		`"Error":"antani"`,
+		`"Error":null`,
+		`"Text":";; opcode: QUERY`,
+		`"PEM":"-----BEGIN CERTIFICATE-----`,
+	} {
+		if !strings.Contains(s, expected) {
+			t.Fatalf("cannot find %s in %s", expected, s)
+		}
+	}
+}
+
+func TestUnitJSONUnsupportedVersion(t *testing.T) {
+	var m model.Measurement
+	err := json.Unmarshal([]byte(`{"Version":2}`), &m)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitJSONInvalidDuration(t *testing.T) {
+	var ev model.ReadEvent
+	err := json.Unmarshal([]byte(`{"Duration":"antani"}`), &ev)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitJSONInvalidPEM(t *testing.T) {
+	var cert model.X509Certificate
+	err := json.Unmarshal([]byte(`{"PEM":"antani"}`), &cert)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitJSONVersionZero(t *testing.T) {
+	var m model.Measurement
+	err := json.Unmarshal([]byte(`{"Read":{"ConnID":3,"Duration":5004069327,
+		"Error":{"Op":"read","Err":{}},"NumBytes":0,"Time":5006073035}}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Read == nil || m.Read.Duration != 5004069327 || m.Read.Time != 5006073035 {
+		t.Fatal("unexpected Read event")
+	}
+	if m.Read.Error == nil || m.Read.Error.Error() != `{"Err":{},"Op":"read"}` {
+		t.Fatal("unexpected Read error")
+	}
+}
+
+func TestUnitDialError(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Err: context.DeadlineExceeded}
+	refused := errors.New("connection refused")
+	err := &model.DialError{
+		Attempts: []model.DialAttempt{
+			{Address: "127.0.0.1:443", Error: timeout},
+			{Address: "[::1]:443", Error: timeout},
+		},
+		Hostname: "example.com",
+		Network:  "tcp",
+	}
+	var netError net.Error
+	if !errors.As(err, &netError) || !netError.Timeout() || !netError.Temporary() {
+		t.Fatal("expected a timeout")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected Unwrap to return the attempts errors")
+	}
+	err.Attempts[1].Error = refused
+	if err.Timeout() || err.Temporary() {
+		t.Fatal("expected not to be a timeout")
+	}
+	if !errors.Is(err, refused) || len(err.Unwrap()) != 2 {
+		t.Fatal("expected Unwrap to return the attempts errors")
+	}
+	if (&model.DialError{}).Timeout() {
+		t.Fatal("expected no attempts not to be a timeout")
+	}
+}
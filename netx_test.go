@@ -3,6 +3,7 @@ package netx_test
 import (
 	"context"
 	"crypto/x509"
+	"io/ioutil"
 	"testing"
 
 	"github.com/ooni/netx"
@@ -32,6 +33,13 @@ func TestIntegrationDialer(t *testing.T) {
 		t.Fatal(err)
 	}
 	conn.Close()
+	conn, err = dialer.DialTLSContext(
+		context.Background(), "tcp", "www.google.com:443",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
 }
 
 func TestIntegrationResolver(t *testing.T) {
@@ -74,3 +82,95 @@ func TestForceSpecificSNI(t *testing.T) {
 		t.Fatal("expected nil conn here")
 	}
 }
+
+func TestSetDNSEngine(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetDNSEngine("godns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetDNSEngine("antani")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetProxy("socks5://127.0.0.1:9050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetProxy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetProxy("ftp://127.0.0.1")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetCaptureData(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetCaptureData(1024, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetCaptureData(-1, 0)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetKeyLogWriter(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetKeyLogWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetSeparateTLSVerification(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetSeparateTLSVerification(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetTLSFingerprint(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetTLSFingerprint("chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetTLSFingerprint("antani")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSetTLSHandshaker(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetTLSHandshaker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSNIHidingSetters(t *testing.T) {
+	dialer := netx.NewDialer(handlers.NoHandler)
+	err := dialer.SetNoSNI(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetArbitrarySNI("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dialer.SetECHConfigList(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,409 @@
+// Package pcapng synthesizes a pcapng file from measurement events,
+// so that we can inspect a measurement using standard tools such as
+// Wireshark.
+//
+// We reconstruct packets from the events. For TCP, a successful
+// ConnectEvent becomes the three way handshake, each WriteEvent and
+// ReadEvent becomes a segment in the proper direction, a ReadEvent
+// failing with EOF becomes a FIN from the peer, a ReadEvent failing
+// with connection_reset becomes a RST from the peer, and a CloseEvent
+// becomes a FIN from us. With happy eyeballs, several successful
+// ConnectEvents may share the same ConnID: each of them becomes a three
+// way handshake, a CloseEvent failing with discarded becomes a RST from
+// us on the connection with the same addresses, and the other events
+// belong to the connection that was not discarded. For UDP, each
+// WriteEvent and ReadEvent becomes a datagram. The godns pseudo
+// connections, which do not have any IP address, are rendered as UDP
+// datagrams between 127.0.0.1 and 127.0.0.53:53, using the
+// DNSQueryEvent and DNSReplyEvent. We do the same for the DNSQueryEvent
+// and DNSReplyEvent of the oodns engine, which have no ConnectEvent,
+// using the ConnID to choose the local port. So, you see the DNS messages exchanged using DoT and DoH in
+// cleartext, and, with the "udp" transport, you also see the same
+// messages as datagrams sent to and received from the real server.
+//
+// The original length of each packet is correct. Yet, packets only
+// contain the payload that has been captured, so make sure you enable
+// payload capture (see netx.Dialer.SetCaptureData) to see the actual
+// bytes. We do not compute the TCP and UDP checksums.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ooni/netx/model"
+)
+
+const (
+	blockTypeSHB     = 0x0A0D0D0A
+	blockTypeIDB     = 0x00000001
+	blockTypeEPB     = 0x00000006
+	byteOrderMagic   = 0x1A2B3C4D
+	linkTypeRaw      = 101 // packets begin with the IPv4 or IPv6 header
+	maxSegmentSize   = 65000
+	protocolTCP      = 6
+	protocolUDP      = 17
+	tcpFlagFIN       = 0x01
+	tcpFlagSYN       = 0x02
+	tcpFlagRST       = 0x04
+	tcpFlagPSH       = 0x08
+	tcpFlagACK       = 0x10
+	pseudoLocalHost  = "127.0.0.1"
+	pseudoRemoteAddr = "127.0.0.53:53"
+)
+
+// Handler is a model.Handler that writes packets in pcapng format. It
+// is goroutine safe.
+type Handler struct {
+	beginning time.Time
+	conns     map[int64][]*conn
+	err       error
+	mutex     sync.Mutex
+	w         io.Writer
+}
+
+type conn struct {
+	local     *net.UDPAddr // also used for TCP
+	localSeq  uint32
+	pseudo    bool
+	remote    *net.UDPAddr
+	remoteSeq uint32
+	tcp       bool
+}
+
+// NewHandler creates a new Handler that writes on w. The beginning is
+// the time used as zero by the code emitting the events; use the time
+// when you created the netx.Dialer or httpx.Client. The pcapng header
+// is written immediately.
+func NewHandler(w io.Writer, beginning time.Time) *Handler {
+	h := &Handler{
+		beginning: beginning,
+		conns:     make(map[int64][]*conn),
+		w:         w,
+	}
+	h.writeHeader()
+	return h
+}
+
+// Err returns the first error that occurred when writing, if any.
+func (h *Handler) Err() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.err
+}
+
+// OnMeasurement writes the packets corresponding to m.
+func (h *Handler) OnMeasurement(m model.Measurement) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if m.Connect != nil && m.Connect.Error == nil {
+		h.onConnect(m.Connect)
+	}
+	if m.Write != nil {
+		h.onIO(m.Write.ConnID, m.Write.Time, false, m.Write.Data,
+			m.Write.NumBytes, m.Write.Failure)
+	}
+	if m.Read != nil {
+		h.onIO(m.Read.ConnID, m.Read.Time, true, m.Read.Data,
+			m.Read.NumBytes, m.Read.Failure)
+	}
+	if m.DNSQuery != nil {
+		h.onDNS(m.DNSQuery.ConnID, m.DNSQuery.Time, false, m.DNSQuery.Message)
+	}
+	if m.DNSReply != nil {
+		h.onDNS(m.DNSReply.ConnID, m.DNSReply.Time, true, m.DNSReply.Message)
+	}
+	if m.Close != nil {
+		h.onClose(m.Close)
+	}
+}
+
+func (h *Handler) onConnect(ev *model.ConnectEvent) {
+	c := &conn{tcp: strings.HasPrefix(ev.Network, "tcp")}
+	c.local, c.remote = parseAddr(ev.LocalAddress), parseAddr(ev.RemoteAddress)
+	if c.local == nil || c.remote == nil {
+		if strings.HasPrefix(ev.Network, "tcp") {
+			return // we don't know how to represent it
+		}
+		c = newPseudoConn(ev.ConnID)
+	}
+	// With happy eyeballs, the first conn is not necessarily the
+	// winner, so we keep all of them until we see which ones have been
+	// discarded (see onClose).
+	h.conns[ev.ConnID] = append(h.conns[ev.ConnID], c)
+	if c.tcp {
+		// Using the ConnID makes the sequence numbers deterministic.
+		c.localSeq, c.remoteSeq = uint32(ev.ConnID)<<16, uint32(ev.ConnID)<<20
+		h.writeTCP(ev.Time, c, false, tcpFlagSYN, nil, 0)
+		c.localSeq++
+		h.writeTCP(ev.Time, c, true, tcpFlagSYN|tcpFlagACK, nil, 0)
+		c.remoteSeq++
+		h.writeTCP(ev.Time, c, false, tcpFlagACK, nil, 0)
+	}
+}
+
+// newPseudoConn creates a conn for rendering DNS messages exchanged
+// by the godns and oodns engines as UDP datagrams.
+func newPseudoConn(connid int64) *conn {
+	return &conn{
+		local: &net.UDPAddr{
+			IP:   net.ParseIP(pseudoLocalHost),
+			Port: 1024 + int(connid%60000),
+		},
+		pseudo: true,
+		remote: parseAddr(pseudoRemoteAddr),
+	}
+}
+
+func (h *Handler) onIO(
+	connid int64, t time.Duration, incoming bool, data []byte,
+	numBytes int64, failure string,
+) {
+	c, found := h.conn(connid)
+	if !found || c.pseudo {
+		return
+	}
+	if !c.tcp {
+		if numBytes > 0 {
+			h.writeUDP(t, c, incoming, data, numBytes)
+		}
+		return
+	}
+	for numBytes > 0 {
+		size := numBytes
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+		var segment []byte
+		if len(data) > 0 {
+			segment = data
+			if int64(len(segment)) > size {
+				segment = segment[:size]
+			}
+			data = data[len(segment):]
+		}
+		h.writeTCP(t, c, incoming, tcpFlagPSH|tcpFlagACK, segment, size)
+		if incoming {
+			c.remoteSeq += uint32(size)
+		} else {
+			c.localSeq += uint32(size)
+		}
+		numBytes -= size
+	}
+	if incoming && failure == "eof_error" {
+		h.writeTCP(t, c, true, tcpFlagFIN|tcpFlagACK, nil, 0)
+		c.remoteSeq++
+	} else if incoming && failure == "connection_reset" {
+		h.writeTCP(t, c, true, tcpFlagRST, nil, 0)
+	}
+}
+
+func (h *Handler) onDNS(
+	connid int64, t time.Duration, incoming bool, message model.DNSMessage,
+) {
+	c, found := h.conn(connid)
+	if found && !c.pseudo {
+		return // this is not a DNS pseudo connection
+	}
+	if !found {
+		// The oodns engine does not emit any ConnectEvent. We don't
+		// save the conn because there is no CloseEvent either.
+		c = newPseudoConn(connid)
+	}
+	h.writeUDP(t, c, incoming, message.Data, int64(len(message.Data)))
+}
+
+func (h *Handler) onClose(ev *model.CloseEvent) {
+	if ev.Failure == "discarded" {
+		h.onDiscard(ev)
+		return
+	}
+	c, found := h.conn(ev.ConnID)
+	if !found {
+		return
+	}
+	delete(h.conns, ev.ConnID)
+	if c.tcp {
+		h.writeTCP(ev.Time, c, false, tcpFlagFIN|tcpFlagACK, nil, 0)
+	}
+}
+
+// onDiscard handles the CloseEvent of a connection that lost the happy
+// eyeballs race, which we find using its addresses.
+func (h *Handler) onDiscard(ev *model.CloseEvent) {
+	local, remote := parseAddr(ev.LocalAddress), parseAddr(ev.RemoteAddress)
+	if local == nil || remote == nil {
+		return
+	}
+	conns := h.conns[ev.ConnID]
+	for idx, c := range conns {
+		if !sameAddr(c.local, local) || !sameAddr(c.remote, remote) {
+			continue
+		}
+		h.conns[ev.ConnID] = append(conns[:idx:idx], conns[idx+1:]...)
+		if len(h.conns[ev.ConnID]) < 1 {
+			delete(h.conns, ev.ConnID)
+		}
+		if c.tcp {
+			h.writeTCP(ev.Time, c, false, tcpFlagRST, nil, 0)
+		}
+		return
+	}
+}
+
+// conn returns the conn used by the events with the specified ConnID,
+// i.e., the first one that has not been discarded.
+func (h *Handler) conn(connid int64) (*conn, bool) {
+	conns := h.conns[connid]
+	if len(conns) < 1 {
+		return nil, false
+	}
+	return conns[0], true
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+func parseAddr(address string) *net.UDPAddr {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	portnum, err := strconv.Atoi(port)
+	if ip == nil || err != nil || portnum < 0 || portnum > 65535 {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip, Port: portnum}
+}
+
+// writeTCP writes a TCP segment. The payload may be shorter than
+// size, which is the real size of the segment payload.
+func (h *Handler) writeTCP(
+	t time.Duration, c *conn, incoming bool, flags byte, payload []byte,
+	size int64,
+) {
+	src, dst, seq, ack := c.local, c.remote, c.localSeq, c.remoteSeq
+	if incoming {
+		src, dst, seq, ack = c.remote, c.local, c.remoteSeq, c.localSeq
+	}
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(header[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(header[4:], seq)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(header[8:], ack)
+	}
+	header[12] = 5 << 4 // data offset in 32 bit words
+	header[13] = flags
+	binary.BigEndian.PutUint16(header[14:], 65535) // window
+	h.writeIP(t, src.IP, dst.IP, protocolTCP, append(header, payload...),
+		int64(len(header))+size)
+}
+
+// writeUDP writes an UDP datagram. The payload may be shorter than
+// size, which is the real size of the datagram payload.
+func (h *Handler) writeUDP(
+	t time.Duration, c *conn, incoming bool, payload []byte, size int64,
+) {
+	src, dst := c.local, c.remote
+	if incoming {
+		src, dst = c.remote, c.local
+	}
+	if int64(len(payload)) > size {
+		payload = payload[:size]
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(header[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(header[4:], uint16(8+size))
+	h.writeIP(t, src.IP, dst.IP, protocolUDP, append(header, payload...),
+		int64(len(header))+size)
+}
+
+// writeIP writes an IP packet. The data may be shorter than size,
+// which is the real size of the IP payload.
+func (h *Handler) writeIP(
+	t time.Duration, src, dst net.IP, protocol byte, data []byte, size int64,
+) {
+	var header []byte
+	if src.To4() != nil && dst.To4() != nil {
+		header = make([]byte, 20)
+		header[0] = 0x45 // version and header length
+		binary.BigEndian.PutUint16(header[2:], uint16(20+size))
+		header[8] = 64 // TTL
+		header[9] = protocol
+		copy(header[12:], src.To4())
+		copy(header[16:], dst.To4())
+		binary.BigEndian.PutUint16(header[10:], checksum(header))
+	} else {
+		header = make([]byte, 40)
+		header[0] = 0x60 // version
+		binary.BigEndian.PutUint16(header[4:], uint16(size))
+		header[6] = protocol
+		header[7] = 64 // hop limit
+		copy(header[8:], src.To16())
+		copy(header[24:], dst.To16())
+	}
+	h.writeEPB(t, append(header, data...), int64(len(header))+size)
+}
+
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func (h *Handler) writeHeader() {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // major version
+	binary.LittleEndian.PutUint16(shb[6:], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[8:], 0xffffffffffffffff)
+	h.writeBlock(blockTypeSHB, shb)
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[4:], 0) // no snaplen
+	h.writeBlock(blockTypeIDB, idb)
+}
+
+func (h *Handler) writeEPB(t time.Duration, packet []byte, size int64) {
+	// The default timestamp resolution is microseconds.
+	ts := uint64(h.beginning.Add(t).UnixNano() / 1000)
+	body := make([]byte, 20)
+	binary.LittleEndian.PutUint32(body[0:], 0) // interface ID
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(size))
+	body = append(body, packet...)
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	h.writeBlock(blockTypeEPB, body)
+}
+
+func (h *Handler) writeBlock(blockType uint32, body []byte) {
+	if h.err != nil {
+		return
+	}
+	block := make([]byte, 8, 12+len(body))
+	length := uint32(12 + len(body))
+	binary.LittleEndian.PutUint32(block[0:], blockType)
+	binary.LittleEndian.PutUint32(block[4:], length)
+	block = append(block, body...)
+	block = append(block, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(block[len(block)-4:], length)
+	_, h.err = h.w.Write(block)
+}
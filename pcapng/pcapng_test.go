@@ -0,0 +1,340 @@
+package pcapng_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/netx/internal/oodns"
+	"github.com/ooni/netx/model"
+	"github.com/ooni/netx/pcapng"
+)
+
+type block struct {
+	blockType uint32
+	body      []byte
+}
+
+func parseBlocks(t *testing.T, data []byte) (out []block) {
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatal("truncated block")
+		}
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatal("invalid block length")
+		}
+		if binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatal("trailing block length mismatch")
+		}
+		out = append(out, block{
+			blockType: binary.LittleEndian.Uint32(data),
+			body:      data[8 : length-4],
+		})
+		data = data[length:]
+	}
+	return
+}
+
+type packet struct {
+	captured []byte
+	length   uint32
+}
+
+func parsePackets(t *testing.T, data []byte) (out []packet) {
+	blocks := parseBlocks(t, data)
+	if len(blocks) < 2 || blocks[0].blockType != 0x0A0D0D0A ||
+		blocks[1].blockType != 1 {
+		t.Fatal("missing section header or interface description")
+	}
+	if binary.LittleEndian.Uint32(blocks[0].body) != 0x1A2B3C4D {
+		t.Fatal("invalid byte order magic")
+	}
+	if binary.LittleEndian.Uint16(blocks[1].body) != 101 {
+		t.Fatal("unexpected link type")
+	}
+	for _, b := range blocks[2:] {
+		if b.blockType != 6 {
+			t.Fatal("expected an enhanced packet block")
+		}
+		capturedLength := binary.LittleEndian.Uint32(b.body[12:])
+		out = append(out, packet{
+			captured: b.body[20 : 20+capturedLength],
+			length:   binary.LittleEndian.Uint32(b.body[16:]),
+		})
+	}
+	return
+}
+
+func TestUnitTCP(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	handler := pcapng.NewHandler(buffer, time.Now())
+	for _, m := range []model.Measurement{{
+		Connect: &model.ConnectEvent{
+			ConnID:        1,
+			Error:         errors.New("connection refused"),
+			Network:       "tcp",
+			RemoteAddress: "[::1]:80",
+		},
+	}, {
+		Connect: &model.ConnectEvent{
+			ConnID:        1,
+			LocalAddress:  "127.0.0.1:54321",
+			Network:       "tcp",
+			RemoteAddress: "127.0.0.1:80",
+		},
+	}, {
+		Write: &model.WriteEvent{
+			ConnID:   1,
+			Data:     []byte("GET / HTTP/1.0\r\n\r\n"),
+			NumBytes: 18,
+		},
+	}, {
+		Read: &model.ReadEvent{
+			ConnID:   1,
+			Data:     []byte("HTTP/1.0 200 Ok\r\n"),
+			NumBytes: 100000,
+		},
+	}, {
+		Read: &model.ReadEvent{
+			ConnID:  1,
+			Error:   errors.New("EOF"),
+			Failure: "eof_error",
+		},
+	}, {
+		Close: &model.CloseEvent{ConnID: 1},
+	}, {
+		Write: &model.WriteEvent{ConnID: 1, NumBytes: 10}, // after close
+	}} {
+		handler.OnMeasurement(m)
+	}
+	if handler.Err() != nil {
+		t.Fatal(handler.Err())
+	}
+	packets := parsePackets(t, buffer.Bytes())
+	// SYN, SYN+ACK, ACK, request, response (two segments), FIN, FIN
+	expectedFlags := []byte{0x02, 0x12, 0x10, 0x18, 0x18, 0x18, 0x11, 0x11}
+	if len(packets) != len(expectedFlags) {
+		t.Fatalf("unexpected number of packets: %d", len(packets))
+	}
+	for i, p := range packets {
+		if p.captured[0] != 0x45 || p.captured[9] != 6 {
+			t.Fatal("expected an IPv4 TCP packet")
+		}
+		if p.captured[20+13] != expectedFlags[i] {
+			t.Fatalf("unexpected TCP flags for packet %d", i)
+		}
+	}
+	request := packets[3]
+	if !bytes.Equal(request.captured[40:], []byte("GET / HTTP/1.0\r\n\r\n")) {
+		t.Fatal("unexpected request payload")
+	}
+	if request.length != 40+18 {
+		t.Fatal("unexpected request length")
+	}
+	if packets[4].length != 40+65000 || packets[5].length != 40+35000 {
+		t.Fatal("unexpected response segments length")
+	}
+	if !bytes.Equal(packets[4].captured[40:], []byte("HTTP/1.0 200 Ok\r\n")) {
+		t.Fatal("unexpected response payload")
+	}
+	if len(packets[5].captured) != 40 {
+		t.Fatal("expected no captured payload in the second segment")
+	}
+	seq := func(p packet) uint32 { return binary.BigEndian.Uint32(p.captured[24:]) }
+	if seq(packets[5]) != seq(packets[4])+65000 {
+		t.Fatal("unexpected sequence number")
+	}
+}
+
+func TestUnitHappyEyeballs(t *testing.T) {
+	winner := &model.ConnectEvent{
+		ConnID:        1,
+		LocalAddress:  "127.0.0.1:54321",
+		Network:       "tcp",
+		RemoteAddress: "127.0.0.1:80",
+	}
+	loser := &model.ConnectEvent{
+		ConnID:        1,
+		LocalAddress:  "127.0.0.1:54322",
+		Network:       "tcp",
+		RemoteAddress: "127.0.0.2:80",
+	}
+	// The loser may also succeed before the winner is chosen, so the
+	// order of the connects does not tell us which one we kept.
+	for _, connects := range [][]*model.ConnectEvent{
+		{winner, loser}, {loser, winner},
+	} {
+		buffer := new(bytes.Buffer)
+		handler := pcapng.NewHandler(buffer, time.Now())
+		for _, ev := range connects {
+			handler.OnMeasurement(model.Measurement{Connect: ev})
+		}
+		for _, m := range []model.Measurement{{
+			Close: &model.CloseEvent{
+				ConnID:        1,
+				Error:         model.ErrDiscarded,
+				Failure:       "discarded",
+				LocalAddress:  loser.LocalAddress,
+				RemoteAddress: loser.RemoteAddress,
+			},
+		}, {
+			Write: &model.WriteEvent{ConnID: 1, NumBytes: 10},
+		}, {
+			Close: &model.CloseEvent{ConnID: 1},
+		}} {
+			handler.OnMeasurement(m)
+		}
+		if handler.Err() != nil {
+			t.Fatal(handler.Err())
+		}
+		packets := parsePackets(t, buffer.Bytes())
+		// Two handshakes, RST of the loser, request and FIN of the winner
+		if len(packets) != 9 {
+			t.Fatalf("unexpected number of packets: %d", len(packets))
+		}
+		srcPort := func(p packet) uint16 {
+			return binary.BigEndian.Uint16(p.captured[20:])
+		}
+		flags := func(p packet) byte { return p.captured[20+13] }
+		rst, request, fin := packets[6], packets[7], packets[8]
+		if flags(rst) != 0x04 || srcPort(rst) != 54322 {
+			t.Fatal("expected a RST for the loser")
+		}
+		if flags(request) != 0x18 || srcPort(request) != 54321 {
+			t.Fatal("expected the request on the winner")
+		}
+		if flags(fin) != 0x11 || srcPort(fin) != 54321 {
+			t.Fatal("expected a FIN for the winner")
+		}
+	}
+}
+
+func TestUnitPseudoConn(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	handler := pcapng.NewHandler(buffer, time.Now())
+	query, reply := []byte("antani"), []byte("mascetti")
+	for _, m := range []model.Measurement{{
+		Connect: &model.ConnectEvent{
+			ConnID:        7,
+			LocalAddress:  "7",
+			Network:       "godns-pseudo-conn",
+			RemoteAddress: "7",
+		},
+	}, {
+		Write: &model.WriteEvent{ConnID: 7, NumBytes: 6},
+		DNSQuery: &model.DNSQueryEvent{
+			ConnID: 7, Message: model.DNSMessage{Data: query},
+		},
+	}, {
+		Read: &model.ReadEvent{ConnID: 7, NumBytes: 8},
+		DNSReply: &model.DNSReplyEvent{
+			ConnID: 7, Message: model.DNSMessage{Data: reply},
+		},
+	}} {
+		handler.OnMeasurement(m)
+	}
+	packets := parsePackets(t, buffer.Bytes())
+	if len(packets) != 2 {
+		t.Fatalf("unexpected number of packets: %d", len(packets))
+	}
+	for i, p := range packets {
+		if p.captured[9] != 17 {
+			t.Fatal("expected an UDP packet")
+		}
+		port := binary.BigEndian.Uint16(p.captured[22-2*i:])
+		if port != 53 {
+			t.Fatal("expected the server port to be 53")
+		}
+	}
+	if !bytes.Equal(packets[0].captured[28:], query) {
+		t.Fatal("unexpected query")
+	}
+	if !bytes.Equal(packets[1].captured[28:], reply) {
+		t.Fatal("unexpected reply")
+	}
+}
+
+func TestUnitOODNS(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	beginning := time.Now()
+	handler := pcapng.NewHandler(buffer, beginning)
+	client := oodns.NewClient(beginning, handler, echoTransport{})
+	_, err := client.Lookup(context.Background(), "example.com", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := parsePackets(t, buffer.Bytes())
+	if len(packets) != 2 {
+		t.Fatalf("unexpected number of packets: %d", len(packets))
+	}
+	for i, p := range packets {
+		if p.captured[9] != 17 {
+			t.Fatal("expected an UDP packet")
+		}
+		port := binary.BigEndian.Uint16(p.captured[22-2*i:])
+		if port != 53 {
+			t.Fatal("expected the server port to be 53")
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(p.captured[28:]); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Response != (i == 1) || msg.Question[0].Name != "example.com." {
+			t.Fatal("unexpected DNS message")
+		}
+	}
+}
+
+// echoTransport is a dnsx.RoundTripper replying to each query
+// with an empty reply.
+type echoTransport struct{}
+
+func (echoTransport) RoundTrip(query []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	return new(dns.Msg).SetReply(msg).Pack()
+}
+
+func TestUnitUDPv6(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	handler := pcapng.NewHandler(buffer, time.Now())
+	handler.OnMeasurement(model.Measurement{
+		Connect: &model.ConnectEvent{
+			ConnID:        3,
+			LocalAddress:  "[::1]:54321",
+			Network:       "udp",
+			RemoteAddress: "[::1]:53",
+		},
+	})
+	handler.OnMeasurement(model.Measurement{
+		Write: &model.WriteEvent{ConnID: 3, Data: []byte("abc"), NumBytes: 3},
+	})
+	packets := parsePackets(t, buffer.Bytes())
+	if len(packets) != 1 {
+		t.Fatal("unexpected number of packets")
+	}
+	p := packets[0]
+	if p.captured[0]>>4 != 6 || p.captured[6] != 17 || p.length != 40+8+3 {
+		t.Fatal("expected an IPv6 UDP packet")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("mocked error")
+}
+
+func TestUnitWriteError(t *testing.T) {
+	handler := pcapng.NewHandler(failingWriter{}, time.Now())
+	if handler.Err() == nil {
+		t.Fatal("expected an error here")
+	}
+}